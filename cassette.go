@@ -0,0 +1,143 @@
+package gooctranspoapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CassetteMode sets whether a cassette records responses from the API, or replays them.
+type CassetteMode int
+
+const (
+	// CassetteReplay serves responses previously recorded to the cassette's directory,
+	// without making any requests.
+	CassetteReplay CassetteMode = iota
+	// CassetteRecord makes requests, and saves each response to the cassette's directory.
+	CassetteRecord
+)
+
+// Cassette returns middleware for WithRoundTripperMiddleware which records
+// request and response pairs as JSON files in dir, or replays them, depending on mode.
+// Requests are matched on their method, path and parameters, so a replayed
+// response is only served for a request with the same parameters as the recorded one.
+// The appID and apiKey aren't part of the match, and aren't saved.
+func Cassette(dir string, mode CassetteMode) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &cassette{dir: dir, mode: mode, next: next}
+	}
+}
+
+type cassette struct {
+	dir  string
+	mode CassetteMode
+	next http.RoundTripper
+}
+
+// cassetteEntry is a recorded request and response pair, as saved to a JSON file.
+type cassetteEntry struct {
+	Request    string      `json:"request"`
+	StatusCode int         `json:"statusCode"`
+	Status     string      `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+func (c *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := cassetteKey(req)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(key))
+	path := filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+
+	if c.mode == CassetteReplay {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("no recorded response for %v: %w", key, err)
+		}
+		entry := cassetteEntry{}
+		err = json.Unmarshal(raw, &entry)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			StatusCode:    entry.StatusCode,
+			Status:        entry.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        entry.Header,
+			Body:          io.NopCloser(strings.NewReader(entry.Body)),
+			ContentLength: int64(len(entry.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	raw, err := json.MarshalIndent(cassetteEntry{
+		Request:    key,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Body:       string(body),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(path, raw, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// cassetteKey returns the method, path and parameters of the request, without the appID
+// and apiKey. The parameters come from the query string, and the form encoded body of
+// a POST, which is read from a copy so the request's own body is left untouched.
+func cassetteKey(req *http.Request) (string, error) {
+	v := req.URL.Query()
+	if req.GetBody != nil && req.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		copied, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		body, err := io.ReadAll(copied)
+		copied.Close()
+		if err != nil {
+			return "", err
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", err
+		}
+		for key, values := range form {
+			v[key] = append(v[key], values...)
+		}
+	}
+	v.Del("appID")
+	v.Del("apiKey")
+	return req.Method + " " + req.URL.Path + "?" + v.Encode(), nil
+}
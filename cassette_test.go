@@ -0,0 +1,126 @@
+package gooctranspoapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassette(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Riverview</RouteLabel>
+            <Direction>Westbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Riverview</TripDestination>
+                <TripStartTime>11:13</TripStartTime>
+                <AdjustedScheduleTime>16</AdjustedScheduleTime>
+                <AdjustmentAge>0.34</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	dir := t.TempDir()
+
+	requests := 0
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+
+	c := NewConnection("someid", "somekey", WithRoundTripperMiddleware(Cassette(dir, CassetteRecord)))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	recorded, err := c.GetNextTripsForStop(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	if requests != 1 {
+		t.Fatal("Expected the request to be made when recording")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Unexpected number of recorded files: %v", len(files))
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "somekey") || strings.Contains(string(raw), "someid") {
+		t.Fatal("Expected the appID and apiKey not to be recorded")
+	}
+
+	c = NewConnection("otherid", "otherkey", WithRoundTripperMiddleware(Cassette(dir, CassetteReplay)))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	replayed, err := c.GetNextTripsForStop(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Fatal("Expected no request to be made when replaying")
+	}
+	if replayed.StopNo != recorded.StopNo || len(replayed.RouteDirections) != len(recorded.RouteDirections) {
+		t.Fatal("Unexpected NextTripsForStop replayed from the cassette")
+	}
+
+	_, err = c.GetNextTripsForStop(context.TODO(), "95", "3020")
+	if err == nil {
+		t.Fatal("Expected error replaying a request with different parameters")
+	}
+
+	req, err := http.NewRequest("POST", ts.URL+"/GetNextTripsForStop", strings.NewReader("stopNo=3020"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	original := req.Body
+	_, err = Cassette(dir, CassetteReplay)(http.DefaultTransport).RoundTrip(req)
+	if err == nil {
+		t.Fatal("Expected error replaying a request which wasn't recorded")
+	}
+	if req.Body != original {
+		t.Fatal("Expected the request's body to be left in place")
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "stopNo=3020" {
+		t.Fatalf("Expected the request's body to be left unread, got %q", body)
+	}
+}
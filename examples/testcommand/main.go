@@ -15,8 +15,8 @@ import (
 const splitterString = "\n\n------------------------------------------------\n\n"
 
 var (
-	id  = flag.String("id", "", "appID")
-	key = flag.String("key", "", "apiKey")
+	id  = flag.String("id", os.Getenv(api.EnvAppID), "appID, defaults to $OCTRANSPO_APP_ID")
+	key = flag.String("key", os.Getenv(api.EnvAPIKey), "apiKey, defaults to $OCTRANSPO_API_KEY")
 )
 
 func main() {
@@ -1,11 +1,14 @@
 module github.com/transitreport/gooctranspoapi
 
+go 1.18
+
 require (
 	github.com/davecgh/go-spew v1.1.1
 	golang.org/x/crypto v0.0.0-20190506204251-e1dfcc566284 // indirect
 	golang.org/x/net v0.0.0-20190503192946-f4e77d36d62c
+	golang.org/x/sync v0.1.0
 	golang.org/x/sys v0.0.0-20190506115046-ca7f33d4116e // indirect
 	golang.org/x/text v0.3.2 // indirect
-	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
+	golang.org/x/time v0.1.0
 	golang.org/x/tools v0.0.0-20190506145303-2d16b83fe98c // indirect
 )
@@ -3,44 +3,443 @@
 package gooctranspoapi
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	_ "embed"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"golang.org/x/net/html/charset"
+	"golang.org/x/sync/singleflight"
 	"golang.org/x/time/rate"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // APIURLPrefix is the address at which the API is available.
 const APIURLPrefix = "https://api.octranspo1.com/v1.3/"
 
+// ScheduleURLPrefix is the address of OC Transpo's web schedules,
+// used by StopScheduleURL and RouteScheduleURL.
+const ScheduleURLPrefix = "https://www.octranspo.com/en/plan-your-trip/schedules-maps/"
+
+// torontoTZData is a copy of the America/Toronto zoneinfo,
+// used when the host has no timezone database, such as in scratch containers.
+//
+//go:embed zoneinfo/America/Toronto
+var torontoTZData []byte
+
+// loadLocation is used to load the America/Toronto location.
+// It's a variable so tests can simulate a host without a timezone database.
+var loadLocation = time.LoadLocation
+
+// torontoLocation returns the America/Toronto location, which the API uses for times.
+// If it can't be loaded from the host, the embedded copy is used.
+func torontoLocation() (*time.Location, error) {
+	tz, err := loadLocation("America/Toronto")
+	if err == nil {
+		return tz, nil
+	}
+	return time.LoadLocationFromTZData("America/Toronto", torontoTZData)
+}
+
+// FixedTorontoOffset returns a location with the fixed UTC offset America/Toronto
+// has at noon on the date. Times parsed in it don't shift at DST transitions,
+// which makes results reproducible around them, such as in tests.
+func FixedTorontoOffset(date time.Time) (*time.Location, error) {
+	tz, err := torontoLocation()
+	if err != nil {
+		return nil, err
+	}
+	name, offset := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, tz).Zone()
+	return time.FixedZone(name, offset), nil
+}
+
 // Connection holds the Application ID and API key needed to make requests.
 // It also has a rate limiter, used by the Connection's methods to
 // limit calls on the API. The HTTP Client is a public field, so that it
 // can be swapped out with a custom HTTP Client if needed.
 type Connection struct {
-	ID            string
-	Key           string
-	Limiter       *rate.Limiter
-	HTTPClient    *http.Client
-	cAPIURLPrefix string
+	ID             string
+	Key            string
+	Limiter        *rate.Limiter
+	HTTPClient     *http.Client
+	cAPIURLPrefix  string
+	inFlight       chan struct{}
+	gtfsValidation bool
+	extraFields    bool
+	strictStops    bool
+	errorPrefix    string
+	debugf         func(format string, v ...interface{})
+	staleThreshold time.Duration
+	location       *time.Location
+	deadlineFloor  time.Duration
+	agencyTZ       *locationCache
+	requestHook    func(RequestInfo)
+	routeSummaries *routeSummaryCache
+	captureTiming  bool
+	flights        *coalescer
+	partialResults bool
+	lenient        bool
+	normalizeRoute bool
+	countdown      CountdownPolicy
+	failFast       bool
+	retry          retryPolicy
+	baseCtx        context.Context
+	maintenance    []string
+}
+
+// Client is the API surface of a Connection. Code which accepts a Client,
+// rather than a Connection, can be tested with a fake implementation.
+type Client interface {
+	GetRouteSummaryForStop(ctx context.Context, stopNo string, options ...RequestOption) (*RouteSummaryForStop, error)
+	GetNextTripsForStop(ctx context.Context, routeNo, stopNo string, options ...RequestOption) (*NextTripsForStop, error)
+	GetNextTripsForStopAllRoutes(ctx context.Context, stopNo string, options ...RequestOption) (*NextTripsForStopAllRoutes, error)
+	GetGTFSAgency(ctx context.Context, options ...RequestOption) (*GTFSAgency, error)
+	GetGTFSCalendar(ctx context.Context, options ...RequestOption) (*GTFSCalendar, error)
+	GetGTFSCalendarDates(ctx context.Context, options ...RequestOption) (*GTFSCalendarDates, error)
+	GetGTFSRoutes(ctx context.Context, options ...RequestOption) (*GTFSRoutes, error)
+	GetGTFSStops(ctx context.Context, options ...RequestOption) (*GTFSStops, error)
+	GetGTFSStopTimes(ctx context.Context, options ...RequestOption) (*GTFSStopTimes, error)
+	GetGTFSTrips(ctx context.Context, options ...RequestOption) (*GTFSTrips, error)
+}
+
+var _ Client = Connection{}
+
+// ConnectionOption configures optional behaviour of a Connection.
+// Options are passed to NewConnection or NewConnectionWithRateLimit.
+type ConnectionOption func(*Connection)
+
+// WithMaxConcurrent limits the connection to n requests in flight at once,
+// independently of the rate limit. This protects against opening many
+// simultaneous connections when requests are made from many goroutines.
+func WithMaxConcurrent(n int) ConnectionOption {
+	return func(c *Connection) {
+		if n > 0 {
+			c.inFlight = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithRoundTripperMiddleware wraps the transport of the connection's HTTP Client
+// with middleware, for example to add headers or handle retries.
+// Middleware options are applied in order, so the last one passed is the outermost.
+// The HTTP Client is copied, so http.DefaultClient isn't modified.
+func WithRoundTripperMiddleware(middleware func(http.RoundTripper) http.RoundTripper) ConnectionOption {
+	return func(c *Connection) {
+		client := *c.HTTPClient
+		transport := client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		client.Transport = middleware(transport)
+		c.HTTPClient = &client
+	}
+}
+
+// RetryMethods selects which requests WithRetry retries, by their HTTP method.
+// The GTFS requests are GETs, and the SOAP requests, such as GetNextTripsForStop, are POSTs.
+type RetryMethods int
+
+const (
+	// RetryGET retries the GTFS requests.
+	RetryGET RetryMethods = 1 << iota
+	// RetryPOST retries the SOAP requests. They're effectively idempotent,
+	// but a conservative policy can leave them out.
+	RetryPOST
+)
+
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+	methods  RetryMethods
+}
+
+// WithRetry retries requests using the methods, such as RetryGET|RetryPOST, which fail
+// with a network error, an empty response, or a 5xx or 429 status, making up to attempts
+// attempts in total. It waits backoff before the first retry, doubling it for each one after.
+// Each attempt waits for the rate limiter, and counts towards the daily quota.
+func WithRetry(attempts int, backoff time.Duration, methods RetryMethods) ConnectionOption {
+	return func(c *Connection) {
+		c.retry = retryPolicy{attempts: attempts, backoff: backoff, methods: methods}
+	}
+}
+
+// retries returns true if the policy retries requests with the method.
+func (p retryPolicy) retries(method string) bool {
+	switch method {
+	case "GET":
+		return p.methods&RetryGET != 0
+	case "POST":
+		return p.methods&RetryPOST != 0
+	}
+	return false
+}
+
+// retryable returns true if the request which failed with err can be retried.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrDeadlineTooShort) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	return errors.Is(err, ErrEmptyResponse) || errors.As(err, &urlErr)
+}
+
+// sendWithRetry sends the request with sendOnce, retrying it according to WithRetry.
+func (c Connection) sendWithRetry(ctx context.Context, req *http.Request) (io.ReadCloser, error) {
+	body, err := c.sendOnce(ctx, req)
+	backoff := c.retry.backoff
+	for attempt := 1; err != nil && attempt < c.retry.attempts && c.retry.retries(req.Method) && retryable(ctx, err); attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, c.prefixError(ctx.Err())
+		}
+		backoff *= 2
+		retry := req.Clone(ctx)
+		if req.GetBody != nil {
+			retry.Body, err = req.GetBody()
+			if err != nil {
+				return nil, c.prefixError(err)
+			}
+		}
+		body, err = c.sendOnce(ctx, retry)
+	}
+	return body, err
+}
+
+// WithDebug logs each request made by the connection, with logf, such as log.Printf.
+// The appID and apiKey are redacted as *** in the logged requests.
+func WithDebug(logf func(format string, v ...interface{})) ConnectionOption {
+	return func(c *Connection) {
+		c.debugf = logf
+	}
+}
+
+// WithStaleThreshold marks route directions returned by GetNextTripsForStop as Stale
+// when their RequestProcessingTime is older than d.
+func WithStaleThreshold(d time.Duration) ConnectionOption {
+	return func(c *Connection) {
+		c.staleThreshold = d
+	}
+}
+
+// WithLocation sets the location used to parse times returned by the API,
+// instead of America/Toronto. Combined with FixedTorontoOffset, this makes
+// time handling independent of the host's timezone database.
+func WithLocation(loc *time.Location) ConnectionOption {
+	return func(c *Connection) {
+		c.location = loc
+	}
+}
+
+// timeLocation returns the location used to parse times returned by the API.
+// If the agency's timezone has been resolved with ResolveTimezone, it's used
+// instead of America/Toronto.
+func (c Connection) timeLocation() (*time.Location, error) {
+	if c.location != nil {
+		return c.location, nil
+	}
+	if loc := c.agencyTZ.get(); loc != nil {
+		return loc, nil
+	}
+	return torontoLocation()
+}
+
+// locationCache holds a location shared between copies of a Connection.
+type locationCache struct {
+	mu  sync.Mutex
+	loc *time.Location
+}
+
+func (lc *locationCache) get() *time.Location {
+	if lc == nil {
+		return nil
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.loc
+}
+
+func (lc *locationCache) set(loc *time.Location) {
+	if lc == nil {
+		return
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.loc = loc
+}
+
+// ErrDeadlineTooShort is returned when the context's deadline doesn't leave enough
+// time for the request, either because the rate limiter would have to wait past it,
+// or because less time remains than the floor set by WithContextDeadlineFloor.
+var ErrDeadlineTooShort = errors.New("context deadline too short for request")
+
+// WithContextDeadlineFloor makes requests fail fast with ErrDeadlineTooShort when
+// their context has less than d remaining before its deadline.
+func WithContextDeadlineFloor(d time.Duration) ConnectionOption {
+	return func(c *Connection) {
+		c.deadlineFloor = d
+	}
+}
+
+// waitLimiter waits for the rate limiter, returning ErrDeadlineTooShort
+// if the context's deadline is too close to make the request.
+func (c Connection) waitLimiter(ctx context.Context) error {
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline && time.Until(deadline) < c.deadlineFloor {
+		return ErrDeadlineTooShort
+	}
+	if ctx.Value(noRateLimitKey{}) != nil {
+		return nil
+	}
+	err := c.Limiter.Wait(ctx)
+	if err != nil && hasDeadline && ctx.Err() == nil {
+		return fmt.Errorf("%w: %v", ErrDeadlineTooShort, err)
+	}
+	return err
+}
+
+// SetBurst changes the burst of the connection's rate limiter, keeping its rate,
+// for example to allow a surge of requests. It's safe to call while requests are
+// being made, and applies to every copy of the Connection sharing the Limiter.
+// The extra requests become available as the limiter refills at its rate.
+func (c *Connection) SetBurst(n int) {
+	c.Limiter.SetBurst(n)
+}
+
+// WithStrictStopResolution returns ErrInvalidStop for stops which have an empty
+// StopDescription or StopLabel and no routes. The API returns these, without an
+// error code, for stop numbers which are well formed but don't exist.
+// By default, they're returned like any other stop.
+func WithStrictStopResolution() ConnectionOption {
+	return func(c *Connection) {
+		c.strictStops = true
+	}
+}
+
+// WithMaintenanceMessages adds messages to those recognized as the API being down for
+// maintenance, which are returned as ErrServiceMaintenance. The messages are matched
+// ignoring case, anywhere in the Error field. They aren't documented, so they can be
+// added as they're seen.
+func WithMaintenanceMessages(messages ...string) ConnectionOption {
+	return func(c *Connection) {
+		c.maintenance = append(c.maintenance, messages...)
+	}
+}
+
+// WithErrorPrefix prefixes the errors returned by the connection's requests
+// with prefix, such as "octranspo", so errors read "octranspo: ...".
+// The original errors can still be checked for with errors.Is and errors.As.
+func WithErrorPrefix(prefix string) ConnectionOption {
+	return func(c *Connection) {
+		c.errorPrefix = prefix
+	}
+}
+
+// prefixError prefixes err with the connection's error prefix, if one is set.
+func (c Connection) prefixError(err error) error {
+	if err == nil || c.errorPrefix == "" {
+		return err
+	}
+	return fmt.Errorf("%v: %w", c.errorPrefix, err)
 }
 
 // NewConnection returns a new connection without a rate limit.
-func NewConnection(id, key string) Connection {
-	return Connection{
-		ID:            id,
-		Key:           key,
-		Limiter:       rate.NewLimiter(rate.Inf, 0),
-		HTTPClient:    http.DefaultClient,
-		cAPIURLPrefix: APIURLPrefix,
+func NewConnection(id, key string, options ...ConnectionOption) Connection {
+	return newConnection(id, key, rate.NewLimiter(rate.Inf, 0), options)
+}
+
+func newConnection(id, key string, limiter *rate.Limiter, options []ConnectionOption) Connection {
+	c := Connection{
+		ID:             id,
+		Key:            key,
+		Limiter:        limiter,
+		HTTPClient:     http.DefaultClient,
+		cAPIURLPrefix:  APIURLPrefix,
+		agencyTZ:       &locationCache{},
+		routeSummaries: newRouteSummaryCache(),
+	}
+	for _, opt := range options {
+		opt(&c)
+	}
+	return c
+}
+
+// The environment variables read by NewConnectionFromEnv.
+const (
+	EnvAppID  = "OCTRANSPO_APP_ID"
+	EnvAPIKey = "OCTRANSPO_API_KEY"
+)
+
+// NewConnectionFromEnv returns a new connection without a rate limit,
+// with the Application ID and API key read from the OCTRANSPO_APP_ID
+// and OCTRANSPO_API_KEY environment variables.
+func NewConnectionFromEnv(options ...ConnectionOption) (Connection, error) {
+	id := os.Getenv(EnvAppID)
+	if id == "" {
+		return Connection{}, fmt.Errorf("the %v environment variable is not set", EnvAppID)
+	}
+	key := os.Getenv(EnvAPIKey)
+	if key == "" {
+		return Connection{}, fmt.Errorf("the %v environment variable is not set", EnvAPIKey)
+	}
+	return NewConnection(id, key, options...), nil
+}
+
+// utf8BOM is the byte order mark some editors put at the start of a key file.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// NewConnectionFromKeyFile returns a new connection without a rate limit,
+// with the Application ID and API key read from a file, such as a Docker or
+// Kubernetes secret. The file is either JSON, as {"appID": "...", "apiKey": "..."},
+// or two lines, the Application ID followed by the API key.
+func NewConnectionFromKeyFile(path string, options ...ConnectionOption) (Connection, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Connection{}, fmt.Errorf("unable to read key file: %w", err)
+	}
+	raw = bytes.TrimSpace(bytes.TrimPrefix(raw, utf8BOM))
+	var id, key string
+	if bytes.HasPrefix(raw, []byte("{")) {
+		creds := struct {
+			AppID  string `json:"appID"`
+			APIKey string `json:"apiKey"`
+		}{}
+		err = json.Unmarshal(raw, &creds)
+		if err != nil {
+			return Connection{}, fmt.Errorf("malformed JSON in key file %v: %w", path, err)
+		}
+		id, key = creds.AppID, creds.APIKey
+	} else {
+		lines := strings.Split(strings.ReplaceAll(string(raw), "\r\n", "\n"), "\n")
+		if len(lines) != 2 {
+			return Connection{}, fmt.Errorf("expected two lines, the appID and apiKey, in key file %v", path)
+		}
+		id, key = strings.TrimSpace(lines[0]), strings.TrimSpace(lines[1])
 	}
+	if id == "" {
+		return Connection{}, fmt.Errorf("no appID in key file %v", path)
+	}
+	if key == "" {
+		return Connection{}, fmt.Errorf("no apiKey in key file %v", path)
+	}
+	return NewConnection(id, key, options...), nil
 }
 
 // NewConnectionWithRateLimit returns a new connection with a rate limit set.
@@ -48,43 +447,603 @@ func NewConnection(id, key string) Connection {
 // which is usually 10,000 requests per day.
 // It you use the connection over 24 hours, a connection with a perSec rate
 // of 0.11572 would make around 9998 requests.
-func NewConnectionWithRateLimit(id, key string, perSec float64, burst int) Connection {
-	return Connection{
-		ID:            id,
-		Key:           key,
-		Limiter:       rate.NewLimiter(rate.Limit(perSec), burst),
-		HTTPClient:    http.DefaultClient,
-		cAPIURLPrefix: APIURLPrefix,
+func NewConnectionWithRateLimit(id, key string, perSec float64, burst int, options ...ConnectionOption) Connection {
+	return newConnection(id, key, rate.NewLimiter(rate.Limit(perSec), burst), options)
+}
+
+// WithBaseContext makes the connection use ctx for the Simple variants of its methods,
+// such as GetNextTripsForStopAllRoutesSimple, for scripts which don't need a context
+// for each call. Canceling ctx cancels their requests.
+// The methods which take a context are the primary API, and don't use ctx.
+func WithBaseContext(ctx context.Context) ConnectionOption {
+	return func(c *Connection) {
+		c.baseCtx = ctx
+	}
+}
+
+// baseContext returns the context set by WithBaseContext, or context.Background.
+func (c Connection) baseContext() context.Context {
+	if c.baseCtx == nil {
+		return context.Background()
+	}
+	return c.baseCtx
+}
+
+// GetRouteSummaryForStopSimple is GetRouteSummaryForStop, using the context set by WithBaseContext.
+func (c Connection) GetRouteSummaryForStopSimple(stopNo string, options ...RequestOption) (*RouteSummaryForStop, error) {
+	return c.GetRouteSummaryForStop(c.baseContext(), stopNo, options...)
+}
+
+// GetNextTripsForStopSimple is GetNextTripsForStop, using the context set by WithBaseContext.
+func (c Connection) GetNextTripsForStopSimple(routeNo, stopNo string, options ...RequestOption) (*NextTripsForStop, error) {
+	return c.GetNextTripsForStop(c.baseContext(), routeNo, stopNo, options...)
+}
+
+// GetNextTripsForStopAllRoutesSimple is GetNextTripsForStopAllRoutes, using the context set by WithBaseContext.
+func (c Connection) GetNextTripsForStopAllRoutesSimple(stopNo string, options ...RequestOption) (*NextTripsForStopAllRoutes, error) {
+	return c.GetNextTripsForStopAllRoutes(c.baseContext(), stopNo, options...)
+}
+
+// acquire waits for a free in-flight slot, if the number of requests in flight is limited.
+func (c Connection) acquire(ctx context.Context) error {
+	if c.inFlight == nil {
+		return nil
+	}
+	select {
+	case c.inFlight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees an in-flight slot taken by acquire.
+func (c Connection) release() {
+	if c.inFlight != nil {
+		<-c.inFlight
+	}
+}
+
+// logRequest logs the request if debugging is on, with the appID and apiKey redacted.
+func (c Connection) logRequest(method string, u url.URL, v url.Values) {
+	if c.debugf == nil {
+		return
 	}
+	u.RawQuery = ""
+	c.debugf("gooctranspoapi: %v %v %v", method, u.String(), redactValues(v))
+}
+
+// redactValues encodes the values, replacing the appID and apiKey with ***.
+func redactValues(v url.Values) string {
+	redacted := url.Values{}
+	for key, values := range v {
+		if key != "appID" && key != "apiKey" {
+			redacted[key] = values
+		}
+	}
+	encoded := "appID=***&apiKey=***"
+	if len(redacted) > 0 {
+		encoded += "&" + redacted.Encode()
+	}
+	return encoded
+}
+
+// releasingBody releases the request's in-flight slot when the response body is closed.
+// Errors reading the body after the request's context is done are wrapped with the context's error.
+type releasingBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	once    sync.Once
+	release func()
+	info    *RequestInfo
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != io.EOF {
+		err = contextError(b.ctx, err)
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
 }
 
-func (c Connection) performRequest(ctx context.Context, u url.URL, v url.Values) (io.ReadCloser, error) {
+// StatusError is returned when the API responds with a non 200 HTTP status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	URL        string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Non 200 HTTP response from API. %v %v", e.Status, e.URL)
+}
+
+func (c Connection) performRequest(ctx context.Context, u url.URL, v url.Values, settings *requestSettings) (io.ReadCloser, error) {
+	ctx = settings.context(ctx)
 	req, err := http.NewRequest("POST", u.String(), strings.NewReader(v.Encode()))
 	if err != nil {
-		return nil, err
+		return nil, c.prefixError(err)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req = req.WithContext(ctx)
-	req.Close = true
+	c.logRequest("POST", u, v)
+	return c.send(ctx, req)
+}
 
-	err = c.Limiter.Wait(ctx)
+// Warmup opens a connection to the API's host, resolving its DNS and completing
+// the TLS handshake, and leaves it idle in the HTTPClient's pool, so the first
+// real request doesn't pay for them. It makes a HEAD request, which isn't an API
+// call, so it doesn't wait for the rate limiter. Any HTTP response, whatever its
+// status, means the connection was established.
+func (c Connection) Warmup(ctx context.Context) error {
+	req, err := http.NewRequest("HEAD", c.cAPIURLPrefix, nil)
 	if err != nil {
-		return nil, err
+		return c.prefixError(err)
+	}
+	req = req.WithContext(ctx)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return c.prefixError(contextError(ctx, err))
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+// send sends the request, sharing the response with identical requests
+// already being made, if WithRequestCoalescing is set.
+func (c Connection) send(ctx context.Context, req *http.Request) (io.ReadCloser, error) {
+	if c.flights == nil {
+		return c.sendWithRetry(ctx, req)
+	}
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	f := c.flights.join(ctx, key)
+	defer c.flights.leave(key, f)
+	results := c.flights.group.DoChan(key, func() (interface{}, error) {
+		shared := req.WithContext(f.ctx)
+		respBody, err := c.sendWithRetry(f.ctx, shared)
+		if err != nil {
+			return nil, err
+		}
+		defer respBody.Close()
+		body, err := io.ReadAll(respBody)
+		if err != nil {
+			return nil, c.prefixError(err)
+		}
+		return body, nil
+	})
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return io.NopCloser(bytes.NewReader(result.Val.([]byte))), nil
+	case <-ctx.Done():
+		return nil, c.prefixError(ctx.Err())
+	}
+}
+
+// coalescer shares requests between the callers waiting for them, for WithRequestCoalescing.
+// Each shared request runs on a context detached from its callers', so that one caller
+// giving up doesn't fail the request for the others, and is canceled once every caller
+// waiting for it has given up.
+type coalescer struct {
+	group   singleflight.Group
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a shared request and the number of callers waiting for it.
+type flight struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// join adds a waiter to the flight for key, starting one on a context detached from ctx
+// if there isn't one.
+func (co *coalescer) join(ctx context.Context, key string) *flight {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	f, ok := co.flights[key]
+	if !ok {
+		f = &flight{}
+		f.ctx, f.cancel = context.WithCancel(detachedContext{ctx})
+		co.flights[key] = f
+	}
+	f.waiters++
+	return f
+}
+
+// leave removes a waiter from the flight for key. When the last waiter leaves, the
+// flight's request is canceled, and forgotten, so later callers start a new one.
+func (co *coalescer) leave(key string, f *flight) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+	f.cancel()
+	if co.flights[key] == f {
+		delete(co.flights, key)
+		co.group.Forget(key)
+	}
+}
+
+// detachedContext keeps the values of its parent, such as WithoutRateLimit,
+// without its deadline or cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// requestKey returns the method, URL and body of the request, which identify identical requests.
+func requestKey(req *http.Request) (string, error) {
+	key := req.Method + " " + req.URL.String()
+	if req.GetBody == nil {
+		return key, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	encoded, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	return key + "\n" + string(encoded), nil
+}
+
+// WithRequestCoalescing makes concurrent identical requests, such as many goroutines
+// asking for the next trips at the same stop, share a single request to the API.
+// The response is read in full by the shared request, and each caller decodes its own copy.
+// The shared request isn't canceled when a caller's context is done; that caller
+// returns the context's error, and the others keep waiting for the response.
+// It's canceled once every caller waiting for it has given up.
+// An error from the shared request is returned to every caller.
+// Results of coalesced requests don't have a Timing set by WithCaptureTiming.
+func WithRequestCoalescing() ConnectionOption {
+	return func(c *Connection) {
+		c.flights = &coalescer{flights: make(map[string]*flight)}
+	}
+}
+
+// sendOnce waits for the rate limiter and an in-flight slot, then sends the request,
+// returning the response body if the API responded with a 200 and a non-empty body.
+// The in-flight slot is released when the body is closed.
+func (c Connection) sendOnce(ctx context.Context, req *http.Request) (io.ReadCloser, error) {
+	waitStart := time.Now()
+	err := c.waitLimiter(ctx)
+	if err != nil {
+		return nil, c.prefixError(err)
 	}
+	limiterWait := time.Since(waitStart)
 
+	err = c.acquire(ctx)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+
+	u := *req.URL
+	u.RawQuery = ""
+	info := &RequestInfo{Method: req.Method, URL: u.String(), LimiterWait: limiterWait}
+	start := time.Now()
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		if resp != nil {
 			resp.Body.Close()
 		}
-		return nil, err
+		c.release()
+		info.Latency = time.Since(start)
+		info.Err = contextError(ctx, err)
+		c.report(*info)
+		return nil, c.prefixError(info.Err)
 	}
+	info.StatusCode = resp.StatusCode
 	if resp.StatusCode != 200 {
 		resp.Body.Close()
-		return nil, fmt.Errorf("Non 200 HTTP response from API. %v %v", resp.Status, u.String())
+		c.release()
+		info.Latency = time.Since(start)
+		info.Err = &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, URL: req.URL.String()}
+		c.report(*info)
+		return nil, c.prefixError(info.Err)
+	}
+	info.Latency = time.Since(start)
+
+	received := time.Now()
+	release := func() {
+		c.release()
+		info.DecodeDuration = time.Since(received)
+		c.report(*info)
+	}
+	body, err := checkEmptyBody(resp.Body)
+	if err != nil {
+		c.release()
+		info.Err = contextError(ctx, err)
+		c.report(*info)
+		return nil, c.prefixError(info.Err)
+	}
+	return &releasingBody{ReadCloser: body, ctx: ctx, release: release, info: info}, nil
+}
+
+// contextError wraps err with the context's error if the context is done,
+// so context.Canceled and context.DeadlineExceeded can be checked for with errors.Is,
+// rather than being masked by the errors they caused.
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ctx.Err(), err)
+}
+
+// RequestInfo describes a request made by a connection,
+// and is passed to the hook set with WithRequestHook.
+type RequestInfo struct {
+	Method string
+	// URL is the URL of the request, without the query string holding the appID and apiKey.
+	URL string
+	// StatusCode is 0 if no response was received.
+	StatusCode int
+	// LimiterWait is the time spent waiting for the rate limiter before the request was sent.
+	LimiterWait time.Duration
+	// Latency is the time from just before the request was sent,
+	// until just after the response's status was checked.
+	Latency time.Duration
+	// DecodeDuration is the time from the response being checked,
+	// until its body was closed after being decoded.
+	DecodeDuration time.Duration
+	// Err is the error from sending the request, a *StatusError, or ErrEmptyResponse.
+	// Errors from decoding the response aren't included.
+	Err error
+}
+
+// WithRequestHook calls hook for each request sent by the connection,
+// once the response has been decoded, or the request has failed.
+// It can be used for logging, or to collect metrics such as latency.
+// The hook may be called from multiple goroutines at once.
+func WithRequestHook(hook func(RequestInfo)) ConnectionOption {
+	return func(c *Connection) {
+		c.requestHook = hook
+	}
+}
+
+// WithCaptureTiming sets the Timing of the results of GetRouteSummaryForStop,
+// GetNextTripsForStop and GetNextTripsForStopAllRoutes, to the RequestInfo of
+// the request which returned them, including how long it waited for the rate limiter.
+func WithCaptureTiming() ConnectionOption {
+	return func(c *Connection) {
+		c.captureTiming = true
+	}
+}
+
+// WithPartialOnTimeout makes the methods which fan out several requests at once,
+// TripsOnRoute, StopsForTrip, PrefetchStatic and PrefetchForStop, return the results
+// which completed when the context's deadline is reached, along with an error wrapping
+// context.DeadlineExceeded, rather than discarding everything.
+func WithPartialOnTimeout() ConnectionOption {
+	return func(c *Connection) {
+		c.partialResults = true
+	}
+}
+
+// timedOut returns true if err was caused by the context's deadline,
+// and partial results should be returned, because WithPartialOnTimeout is set.
+func (c Connection) timedOut(err error) bool {
+	return c.partialResults && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrDeadlineTooShort))
+}
+
+// partialError returns the error for a fan out where missing of total requests timed out.
+func (c Connection) partialError(missing, total int) error {
+	return c.prefixError(fmt.Errorf("%w: %v of %v requests didn't complete", context.DeadlineExceeded, missing, total))
+}
+
+// WithLenientParsing makes GetNextTripsForStop and GetNextTripsForStopAllRoutes
+// leave optional trip fields which can't be parsed, such as a malformed Latitude,
+// unset rather than failing, recording a ParseWarning for each in the result's Warnings.
+func WithLenientParsing() ConnectionOption {
+	return func(c *Connection) {
+		c.lenient = true
+	}
+}
+
+// NormalizeRouteNo returns the route number without leading zeros, so "06" becomes "6",
+// matching the route numbers used by the API. Route numbers which don't start with a zero,
+// such as "R1", are returned unchanged, other than surrounding whitespace being trimmed.
+func NormalizeRouteNo(s string) string {
+	s = strings.TrimSpace(s)
+	trimmed := strings.TrimLeft(s, "0")
+	if trimmed == "" && s != "" {
+		return "0"
+	}
+	return trimmed
+}
+
+// WithRouteNoNormalization applies NormalizeRouteNo to the route numbers passed to
+// the connection's methods, both those sent to the API, such as by GetNextTripsForStop,
+// and those compared to the API's route numbers, such as by StopServicesRoute and
+// NextTripsRequest.Route, so "06" and "6" are treated as the same route.
+func WithRouteNoNormalization() ConnectionOption {
+	return func(c *Connection) {
+		c.normalizeRoute = true
+	}
+}
+
+// routeNo returns the route number, normalized if WithRouteNoNormalization is set.
+func (c Connection) routeNo(s string) string {
+	if c.normalizeRoute {
+		return NormalizeRouteNo(s)
+	}
+	return s
+}
+
+// ParseWarning records an optional trip field which couldn't be parsed with WithLenientParsing.
+type ParseWarning struct {
+	RouteNo       string
+	TripStartTime string
+	Field         string
+	Value         string
+	Err           error
+}
+
+func (w ParseWarning) String() string {
+	return fmt.Sprintf("route %v trip %v: invalid %v %q: %v", w.RouteNo, w.TripStartTime, w.Field, w.Value, w.Err)
+}
+
+// WithFailFast makes the methods which fan out several requests at once, TripsOnRoute,
+// StopsForTrip, PrefetchStatic and PrefetchForStop, cancel the requests still outstanding
+// as soon as one fails, to save quota, returning the error of the first to fail.
+func WithFailFast() ConnectionOption {
+	return func(c *Connection) {
+		c.failFast = true
+	}
+}
+
+// fanOut calls each fn concurrently, returning their errors. Each fn is passed a context
+// derived from ctx which, with WithFailFast, is canceled as soon as one fails, and failed
+// is the first error. Errors which WithPartialOnTimeout returns partial results for don't
+// cancel the others.
+func (c Connection) fanOut(ctx context.Context, fns ...func(ctx context.Context) error) (errs []error, failed error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs = make([]error, len(fns))
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn func(ctx context.Context) error) {
+			defer wg.Done()
+			errs[i] = fn(ctx)
+			if errs[i] != nil && c.failFast && !c.timedOut(errs[i]) {
+				once.Do(func() {
+					failed = errs[i]
+					cancel()
+				})
+			}
+		}(i, fn)
+	}
+	wg.Wait()
+	return errs, failed
+}
+
+// timing returns a copy of the RequestInfo of the closed response body, if WithCaptureTiming is set.
+func (c Connection) timing(body io.ReadCloser) *RequestInfo {
+	rb, ok := body.(*releasingBody)
+	if !c.captureTiming || !ok {
+		return nil
+	}
+	info := *rb.info
+	return &info
+}
+
+// report passes the request info to the request hook, if one is set.
+func (c Connection) report(info RequestInfo) {
+	if c.requestHook != nil {
+		c.requestHook(info)
+	}
+}
+
+// decodeSOAP decodes the SOAP Envelope in the response into data. A leading byte order
+// mark is skipped by the decoder, and the Envelope is searched for, so responses where a gateway has
+// wrapped it in another element can still be decoded. Responses labeled as UTF-8 which
+// aren't valid UTF-8 are decoded as Windows-1252, a superset of Latin-1, since the API
+// has been seen sending Latin-1 accented names, such as "Aéroport", labeled as UTF-8.
+func decodeSOAP(r io.Reader, data interface{}) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var src io.Reader = bytes.NewReader(body)
+	if !utf8.Valid(body) && declaresUTF8(body) {
+		src, err = charset.NewReaderLabel("windows-1252", src)
+		if err != nil {
+			return err
+		}
+	}
+	dec := xml.NewDecoder(src)
+	dec.CharsetReader = charset.NewReaderLabel
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return errors.New("no SOAP Envelope in the response")
+		}
+		if err != nil {
+			return err
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "Envelope" {
+			return dec.DecodeElement(data, &se)
+		}
 	}
+}
 
-	return resp.Body, nil
+// declaresUTF8 returns true if the XML prolog declares the UTF-8 encoding,
+// or doesn't declare an encoding, which means UTF-8.
+func declaresUTF8(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if !bytes.HasPrefix(body, []byte("<?xml")) {
+		return true
+	}
+	end := bytes.Index(body, []byte("?>"))
+	if end < 0 {
+		return true
+	}
+	prolog := strings.ToLower(string(body[:end]))
+	i := strings.Index(prolog, "encoding=")
+	if i < 0 {
+		return true
+	}
+	fields := strings.Fields(prolog[i+len("encoding="):])
+	if len(fields) == 0 {
+		return true
+	}
+	enc := strings.Trim(fields[0], `"'`)
+	return enc == "utf-8" || enc == "utf8"
+}
+
+// ErrEmptyResponse is returned when the API responds with a 200 but an empty body,
+// which has been seen during outages.
+var ErrEmptyResponse = errors.New("empty response from the API")
+
+// checkEmptyBody returns ErrEmptyResponse if the body is empty or only whitespace,
+// closing it. Otherwise the leading whitespace is skipped and the rest of the body is returned.
+func checkEmptyBody(body io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(body)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			body.Close()
+			return nil, ErrEmptyResponse
+		}
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		br.UnreadByte()
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, body}, nil
+	}
 }
 
 // RouteSummaryForStop is a simplified version of the data returned by
@@ -94,6 +1053,8 @@ type RouteSummaryForStop struct {
 	StopDescription string
 	Error           string
 	Routes          []Route
+	// Timing is set when the connection has WithCaptureTiming.
+	Timing *RequestInfo
 }
 
 // Route is used by RouteSummaryForStop to store route data.
@@ -118,8 +1079,9 @@ type rawRouteSummaryForStop struct {
 			Text                         string `xml:",chardata"`
 			Xmlns                        string `xml:"xmlns,attr"`
 			GetRouteSummaryForStopResult struct {
-				Text   string `xml:",chardata"`
-				StopNo struct {
+				Text      string `xml:",chardata"`
+				ErrorAttr string `xml:"error,attr"`
+				StopNo    struct {
 					Text  string `xml:",chardata"`
 					Xmlns string `xml:"xmlns,attr"`
 				} `xml:"StopNo"`
@@ -148,12 +1110,13 @@ type rawRouteSummaryForStop struct {
 }
 
 // Cook takes a raw XML RouteSummaryForStop and simplifies it.
-func (d *rawRouteSummaryForStop) cook() (*RouteSummaryForStop, error) {
+func (d *rawRouteSummaryForStop) cook(maintenance []string) (*RouteSummaryForStop, error) {
 	cooked := &RouteSummaryForStop{}
 	cooked.StopNo = d.Body.GetRouteSummaryForStopResponse.GetRouteSummaryForStopResult.StopNo.Text
 	cooked.StopDescription = d.Body.GetRouteSummaryForStopResponse.GetRouteSummaryForStopResult.StopDescription.Text
 
-	errorText, err := checkErrorCode(d.Body.GetRouteSummaryForStopResponse.GetRouteSummaryForStopResult.Error.Text)
+	result := d.Body.GetRouteSummaryForStopResponse.GetRouteSummaryForStopResult
+	errorText, err := checkErrorCode(resultError(result.Error.Text, result.ErrorAttr), maintenance)
 	if err != nil {
 		return nil, err
 	}
@@ -171,32 +1134,291 @@ func (d *rawRouteSummaryForStop) cook() (*RouteSummaryForStop, error) {
 }
 
 // GetRouteSummaryForStop returns the routes for a given stop number.
-func (c Connection) GetRouteSummaryForStop(ctx context.Context, stopNo string) (*RouteSummaryForStop, error) {
+// Options, such as Param, can be passed to add values to the request.
+func (c Connection) GetRouteSummaryForStop(ctx context.Context, stopNo string, options ...RequestOption) (*RouteSummaryForStop, error) {
 	u, err := url.Parse(c.cAPIURLPrefix + "GetRouteSummaryForStop")
 	if err != nil {
-		return nil, err
+		return nil, c.prefixError(err)
 	}
 	v := url.Values{}
 	v.Set("appID", c.ID)
 	v.Set("apiKey", c.Key)
 	v.Set("stopNo", stopNo)
+	settings, err := applyOptions(v, options)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
 
-	respBody, err := c.performRequest(ctx, *u, v)
+	respBody, err := c.performRequest(ctx, *u, v, settings)
 	if err != nil {
 		return nil, err
 	}
 
-	dec := xml.NewDecoder(respBody)
-	dec.CharsetReader = charset.NewReaderLabel
-	dec.Strict = false
 	data := &rawRouteSummaryForStop{}
-	err = dec.Decode(data)
+	err = decodeSOAP(respBody, data)
 	respBody.Close()
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+
+	cooked, err := data.cook(c.maintenance)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	if c.strictStops && cooked.StopDescription == "" && len(cooked.Routes) == 0 {
+		return nil, c.prefixError(ErrInvalidStop)
+	}
+	cooked.Timing = c.timing(respBody)
+	return cooked, nil
+}
+
+// GroupByRoute returns the routes grouped by route number.
+// The directions for each route number keep the order they were returned in by the API.
+func (r *RouteSummaryForStop) GroupByRoute() map[string][]Route {
+	grouped := make(map[string][]Route)
+	for _, route := range r.Routes {
+		grouped[route.RouteNo] = append(grouped[route.RouteNo], route)
+	}
+	return grouped
+}
+
+// DirectionsForRoute returns the directions of a route at the stop, in the order they
+// were returned in by the API, such as for choosing a direction before calling
+// GetNextTripsForStop. It returns nil if the route doesn't serve the stop.
+func (r *RouteSummaryForStop) DirectionsForRoute(routeNo string) []Route {
+	var directions []Route
+	for _, route := range r.Routes {
+		if route.RouteNo == routeNo {
+			directions = append(directions, route)
+		}
+	}
+	return directions
+}
+
+// DiffRouteSummaries returns the routes in new which aren't in old, and the routes in
+// old which aren't in new, such as routes added to or removed from a stop.
+// Routes are matched on their RouteNo, DirectionID and Direction. A nil summary has no routes.
+func DiffRouteSummaries(old, new *RouteSummaryForStop) (added, removed []Route) {
+	key := func(r Route) [3]string {
+		return [3]string{r.RouteNo, r.DirectionID, r.Direction}
+	}
+	routes := func(s *RouteSummaryForStop) []Route {
+		if s == nil {
+			return nil
+		}
+		return s.Routes
+	}
+	inOld := make(map[[3]string]bool)
+	for _, r := range routes(old) {
+		inOld[key(r)] = true
+	}
+	inNew := make(map[[3]string]bool)
+	for _, r := range routes(new) {
+		inNew[key(r)] = true
+		if !inOld[key(r)] {
+			added = append(added, r)
+		}
+	}
+	for _, r := range routes(old) {
+		if !inNew[key(r)] {
+			removed = append(removed, r)
+		}
+	}
+	return added, removed
+}
+
+// RouteSummaryChanged returns true if routes were added to or removed from the stop
+// between the old and new summaries, for refreshing a cached mapping of routes to stops.
+func RouteSummaryChanged(old, new *RouteSummaryForStop) bool {
+	added, removed := DiffRouteSummaries(old, new)
+	return len(added) > 0 || len(removed) > 0
+}
+
+// routeSummaryTTL is how long StopServicesRoute reuses a stop's route summary.
+const routeSummaryTTL = 5 * time.Minute
+
+// routeSummaryCache holds route summaries shared between copies of a Connection.
+// A janitor goroutine, started with the first entry, evicts expired entries
+// every ttl, until the cache is closed.
+type routeSummaryCache struct {
+	mu      sync.Mutex
+	entries map[string]routeSummaryEntry
+	ttl     time.Duration
+	started bool
+	closed  bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newRouteSummaryCache() *routeSummaryCache {
+	return &routeSummaryCache{
+		entries: make(map[string]routeSummaryEntry),
+		ttl:     routeSummaryTTL,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// get returns a copy of the route summary stored under key, if it hasn't expired.
+func (rc *routeSummaryCache) get(key string) (*RouteSummaryForStop, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry, ok := rc.entries[key]
+	if !ok || time.Since(entry.fetched) >= rc.ttl {
+		return nil, false
+	}
+	return entry.summary.copy(), true
+}
+
+// put stores a copy of the route summary under key, starting the janitor if it isn't running.
+func (rc *routeSummaryCache) put(key string, summary *RouteSummaryForStop) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries[key] = routeSummaryEntry{summary: summary.copy(), fetched: time.Now()}
+	if !rc.started && !rc.closed {
+		rc.started = true
+		go rc.janitor()
+	}
+}
+
+// janitor evicts expired entries every ttl, until the cache is closed.
+func (rc *routeSummaryCache) janitor() {
+	defer close(rc.stopped)
+	ticker := time.NewTicker(rc.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rc.stop:
+			return
+		case <-ticker.C:
+			rc.mu.Lock()
+			for key, entry := range rc.entries {
+				if time.Since(entry.fetched) >= rc.ttl {
+					delete(rc.entries, key)
+				}
+			}
+			rc.mu.Unlock()
+		}
+	}
+}
+
+// close stops the janitor, waiting for it to exit. It's safe to call more than once.
+func (rc *routeSummaryCache) close() {
+	rc.mu.Lock()
+	if rc.closed {
+		rc.mu.Unlock()
+		return
+	}
+	rc.closed = true
+	started := rc.started
+	rc.mu.Unlock()
+	close(rc.stop)
+	if started {
+		<-rc.stopped
+	}
+}
+
+// Close stops the goroutine which evicts expired route summaries cached by
+// StopServicesRoute, so long-lived processes which discard connections don't leak it.
+// It's shared between copies of the Connection, and is safe to call more than once.
+// The connection can still be used afterwards, but expired entries are only
+// replaced when the stop is checked again, rather than evicted.
+func (c Connection) Close() error {
+	if c.routeSummaries != nil {
+		c.routeSummaries.close()
+	}
+	return nil
+}
+
+type routeSummaryEntry struct {
+	summary *RouteSummaryForStop
+	fetched time.Time
+}
+
+// copy returns a copy of the route summary which shares none of its routes or timing.
+func (s *RouteSummaryForStop) copy() *RouteSummaryForStop {
+	cp := *s
+	cp.Routes = append([]Route(nil), s.Routes...)
+	if s.Timing != nil {
+		timing := *s.Timing
+		cp.Timing = &timing
+	}
+	return &cp
+}
+
+// StopServicesRoute returns true if the route serves the stop, according to
+// GetRouteSummaryForStop. It can be used to avoid polling route and stop
+// combinations which don't exist. Route summaries are reused for five minutes,
+// so checking several routes at a stop makes a single request.
+// Options, such as WithCredentials, are passed to GetRouteSummaryForStop,
+// and summaries are only reused for requests with the same parameters.
+func (c Connection) StopServicesRoute(ctx context.Context, stopNo, routeNo string, options ...RequestOption) (bool, error) {
+	summary, err := c.cachedRouteSummary(ctx, stopNo, options...)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range summary.Routes {
+		if c.routeNo(r.RouteNo) == c.routeNo(routeNo) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cachedRouteSummary returns the stop's route summary from the cache, or from
+// GetRouteSummaryForStop. Summaries are cached under the parameters of the request,
+// including the appID and apiKey, so connections sharing the cache with other
+// credentials, or requests with WithCredentials, don't see each other's summaries.
+func (c Connection) cachedRouteSummary(ctx context.Context, stopNo string, options ...RequestOption) (*RouteSummaryForStop, error) {
+	if c.routeSummaries == nil {
+		return c.GetRouteSummaryForStop(ctx, stopNo, options...)
+	}
+	v := url.Values{}
+	v.Set("appID", c.ID)
+	v.Set("apiKey", c.Key)
+	v.Set("stopNo", stopNo)
+	_, err := applyOptions(v, options)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	key := v.Encode()
+	if summary, ok := c.routeSummaries.get(key); ok {
+		return summary, nil
+	}
+
+	summary, err := c.GetRouteSummaryForStop(ctx, stopNo, options...)
 	if err != nil {
 		return nil, err
 	}
+	c.routeSummaries.put(key, summary)
+	return summary, nil
+}
+
+// StopNamer is implemented by the results of GetRouteSummaryForStop, GetNextTripsForStop
+// and GetNextTripsForStopAllRoutes, which name the stop in different fields.
+type StopNamer interface {
+	StopName() string
+}
+
+var (
+	_ StopNamer = (*RouteSummaryForStop)(nil)
+	_ StopNamer = (*NextTripsForStop)(nil)
+	_ StopNamer = (*NextTripsForStopAllRoutes)(nil)
+)
+
+// StopName returns the StopDescription.
+func (r *RouteSummaryForStop) StopName() string {
+	return r.StopDescription
+}
+
+// StopName returns the StopLabel.
+func (n *NextTripsForStop) StopName() string {
+	return n.StopLabel
+}
 
-	return data.cook()
+// StopName returns the StopDescription.
+func (n *NextTripsForStopAllRoutes) StopName() string {
+	return n.StopDescription
 }
 
 // NextTripsForStop is a simplified version of the data returned by
@@ -206,6 +1428,10 @@ type NextTripsForStop struct {
 	StopLabel       string
 	Error           string
 	RouteDirections []RouteDirection
+	// Timing is set when the connection has WithCaptureTiming.
+	Timing *RequestInfo
+	// Warnings lists optional trip fields which couldn't be parsed, with WithLenientParsing.
+	Warnings []ParseWarning
 }
 
 // RouteDirection is used by NextTripsForStop to store route direction data.
@@ -216,6 +1442,61 @@ type RouteDirection struct {
 	Error                 string
 	RequestProcessingTime time.Time
 	Trips                 []Trip
+	// Stale is set when the connection has a stale threshold set with
+	// WithStaleThreshold, and the RequestProcessingTime is older than it.
+	Stale bool
+}
+
+// TripsWithin returns the trips which are expected within minutes.
+func (rd RouteDirection) TripsWithin(minutes int) []Trip {
+	return tripsWithin(rd.Trips, minutes)
+}
+
+// Bounds returns the bounding box of the route direction's trips which have a position,
+// for framing them on a map. ok is false when none of the trips have a position.
+func (rd RouteDirection) Bounds() (minLat, minLon, maxLat, maxLon float64, ok bool) {
+	return tripBounds(rd.Trips)
+}
+
+// Staleness returns how long before now the API processed the request.
+// A large staleness indicates the data came from a cache.
+func (rd RouteDirection) Staleness(now time.Time) time.Duration {
+	return now.Sub(rd.RequestProcessingTime)
+}
+
+// ClockSkew returns the difference between now, from the client's clock, and the
+// RequestProcessingTime, from the server's clock. It's the same as Staleness, but
+// named for checking clocks: shortly after a request, it should be close to zero,
+// so a large skew means either clock is wrong, or the data came from a stale cache.
+func (rd RouteDirection) ClockSkew(now time.Time) time.Duration {
+	return rd.Staleness(now)
+}
+
+// ArrivalTime returns when the trip is expected at the stop, its AdjustedScheduleTime
+// in minutes after the RequestProcessingTime. live is true when the time is a prediction
+// based on GPS data. When the trip's AdjustmentAge is -1, the time is only an estimate
+// from the schedule, and live is false.
+func (rd RouteDirection) ArrivalTime(t Trip) (arrival time.Time, live bool) {
+	arrival = rd.RequestProcessingTime.Add(time.Duration(t.AdjustedScheduleTime) * time.Minute)
+	return arrival, t.IsRealTime()
+}
+
+// ArrivalTimeRFC3339 returns the trip's ArrivalTime formatted as RFC3339,
+// such as "2019-03-28T13:22:00-04:00", in the agency's timezone, for JSON and logs.
+func (rd RouteDirection) ArrivalTimeRFC3339(t Trip) string {
+	arrival, _ := rd.ArrivalTime(t)
+	return arrival.Format(time.RFC3339)
+}
+
+// LastTrip returns the trip flagged by the API as the last trip of the schedule,
+// and whether there is one. Its Countdown can be used to warn that the last bus is close.
+func (rd RouteDirection) LastTrip() (Trip, bool) {
+	for _, t := range rd.Trips {
+		if t.LastTripOfSchedule.Set && t.LastTripOfSchedule.Value {
+			return t, true
+		}
+	}
+	return Trip{}, false
 }
 
 // Trip stores trip data, and includes the adjusted schedule time.
@@ -231,6 +1512,209 @@ type Trip struct {
 	GPSSpeed
 }
 
+// BusType codes, such as "6EB - 60" or "4LB - DD", describe the bus serving a trip.
+// The part before the dash starts with the bus length, 4 for 40 foot or 6 for 60 foot,
+// followed by feature letters: E for low floor easy access, L for low floor,
+// A for a lift or ramp, and B for a bike rack.
+// The part after the dash is the bus model: 40 or 60 for the bus length,
+// DD for a double decker, and DEH for a diesel electric hybrid.
+// Either part can be missing, as in " - DD".
+
+// busTypeParts splits a BusType into its features and model parts.
+func (t Trip) busTypeParts() (features, model string) {
+	parts := strings.SplitN(t.BusType, "-", 2)
+	features = strings.TrimSpace(parts[0])
+	if len(parts) == 2 {
+		model = strings.TrimSpace(parts[1])
+	}
+	return features, model
+}
+
+// IsAccessible returns true if the BusType indicates a low floor, or a lift or ramp.
+func (t Trip) IsAccessible() bool {
+	features, _ := t.busTypeParts()
+	return strings.ContainsAny(strings.TrimLeft(features, "0123456789"), "ELA")
+}
+
+// IsDoubleDecker returns true if the BusType indicates a double decker bus.
+func (t Trip) IsDoubleDecker() bool {
+	_, model := t.busTypeParts()
+	return model == "DD"
+}
+
+// IsArticulated returns true if the BusType indicates a 60 foot articulated bus.
+func (t Trip) IsArticulated() bool {
+	features, model := t.busTypeParts()
+	return model == "60" || strings.HasPrefix(features, "6")
+}
+
+// IsRealTime returns true if the trip's AdjustedScheduleTime is based on GPS data.
+// The API sets AdjustmentAge to -1 for trips which only have scheduled times.
+func (t Trip) IsRealTime() bool {
+	return t.AdjustmentAge >= 0
+}
+
+// CountdownPolicy sets how Countdown shows trips which are about to arrive.
+type CountdownPolicy int
+
+const (
+	// CountdownDue shows "Due" when the trip's AdjustedScheduleTime is 0 or less.
+	CountdownDue CountdownPolicy = iota
+	// CountdownDueWithinMinute shows "Due" when the trip's AdjustedScheduleTime is 1 or less,
+	// since the API rounds the time, and the GPS data may be a minute old.
+	CountdownDueWithinMinute
+	// CountdownArriving shows "Arriving" when the trip's AdjustedScheduleTime is 0 or less,
+	// and "Due" when it's 1.
+	CountdownArriving
+	// CountdownLessThanMinute shows "<1 min" when the trip's AdjustedScheduleTime is 0 or less.
+	CountdownLessThanMinute
+)
+
+// WithCountdownPolicy sets the CountdownPolicy used by the connection's Countdown.
+// The default is CountdownDue.
+func WithCountdownPolicy(policy CountdownPolicy) ConnectionOption {
+	return func(c *Connection) {
+		c.countdown = policy
+	}
+}
+
+// Countdown returns the trip's AdjustedScheduleTime for display, using the
+// CountdownPolicy set with WithCountdownPolicy.
+func (c Connection) Countdown(t Trip) string {
+	return t.CountdownWith(c.countdown)
+}
+
+// Countdown returns the trip's AdjustedScheduleTime for display, such as "5 min",
+// or "Due" when the bus is expected now, following CountdownDue.
+func (t Trip) Countdown() string {
+	return t.CountdownWith(CountdownDue)
+}
+
+// CountdownWith returns the trip's AdjustedScheduleTime for display, using the policy.
+func (t Trip) CountdownWith(policy CountdownPolicy) string {
+	minutes := t.AdjustedScheduleTime
+	switch {
+	case policy == CountdownDueWithinMinute && minutes <= 1:
+		return "Due"
+	case policy == CountdownArriving && minutes <= 0:
+		return "Arriving"
+	case policy == CountdownArriving && minutes == 1:
+		return "Due"
+	case policy == CountdownLessThanMinute && minutes <= 0:
+		return "<1 min"
+	case minutes <= 0:
+		return "Due"
+	}
+	return fmt.Sprintf("%d min", minutes)
+}
+
+// DelayVsSchedule returns how many minutes later than scheduled the trip is expected,
+// comparing its AdjustedScheduleTime to scheduledMinutesAway, which can be found with
+// ScheduledMinutesAway. A negative delay means the trip is running early.
+func (t Trip) DelayVsSchedule(scheduledMinutesAway int) int {
+	return t.AdjustedScheduleTime - scheduledMinutesAway
+}
+
+// SameTrip returns true if a and b are the same trip, such as from consecutive polls
+// of a stop, even though their AdjustedScheduleTime and position have changed.
+// Trips are matched on their TripDestination and TripStartTime, and their BusType,
+// unless either is unknown, since the API leaves it empty for some trips.
+func SameTrip(a, b Trip) bool {
+	if a.TripDestination != b.TripDestination || a.TripStartTime != b.TripStartTime {
+		return false
+	}
+	return a.BusType == "" || b.BusType == "" || a.BusType == b.BusType
+}
+
+// HasPosition returns true if the API set both the trip's latitude and longitude.
+func (t Trip) HasPosition() bool {
+	return t.Latitude.Set && t.Longitude.Set
+}
+
+// EstimatedETAFromGPS is a rough heuristic for when the bus will reach a stop,
+// from the straight line distance between the trip's position and the stop,
+// divided by its GPSSpeed, taken as km/h. It ignores the route's actual path,
+// stops along the way and traffic, so it's only useful for sanity checking
+// the API's AdjustedScheduleTime. ok is false when the trip has no position
+// or speed, or the bus isn't moving.
+func (t Trip) EstimatedETAFromGPS(stopLat, stopLon float64) (eta time.Duration, ok bool) {
+	if !t.HasPosition() || !t.GPSSpeed.Set || t.GPSSpeed.Value <= 0 {
+		return 0, false
+	}
+	km := distanceKm(t.Latitude.Value, t.Longitude.Value, stopLat, stopLon)
+	return time.Duration(km / t.GPSSpeed.Value * float64(time.Hour)), true
+}
+
+// distanceKm returns the great circle distance between two points, in kilometres.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// tripBounds returns the bounding box of the trips with positions.
+func tripBounds(trips []Trip) (minLat, minLon, maxLat, maxLon float64, ok bool) {
+	for _, t := range trips {
+		if !t.HasPosition() {
+			continue
+		}
+		if !ok {
+			minLat, maxLat = t.Latitude.Value, t.Latitude.Value
+			minLon, maxLon = t.Longitude.Value, t.Longitude.Value
+			ok = true
+			continue
+		}
+		minLat = math.Min(minLat, t.Latitude.Value)
+		maxLat = math.Max(maxLat, t.Latitude.Value)
+		minLon = math.Min(minLon, t.Longitude.Value)
+		maxLon = math.Max(maxLon, t.Longitude.Value)
+	}
+	return minLat, minLon, maxLat, maxLon, ok
+}
+
+// PublicTrip is a Trip with plain JSON friendly fields, for serving to other clients.
+// Optional values which weren't set by the API are nil, and encode as null,
+// so every field is always present in the JSON.
+type PublicTrip struct {
+	TripDestination      string   `json:"tripDestination"`
+	TripStartTime        string   `json:"tripStartTime"`
+	AdjustedScheduleTime int      `json:"adjustedScheduleTime"`
+	AdjustmentAge        float64  `json:"adjustmentAge"`
+	LastTripOfSchedule   *bool    `json:"lastTripOfSchedule"`
+	BusType              string   `json:"busType"`
+	Latitude             *float64 `json:"latitude"`
+	Longitude            *float64 `json:"longitude"`
+	GPSSpeed             *float64 `json:"gpsSpeed"`
+}
+
+// Public returns the trip as a PublicTrip.
+func (t Trip) Public() PublicTrip {
+	p := PublicTrip{
+		TripDestination:      t.TripDestination,
+		TripStartTime:        t.TripStartTime,
+		AdjustedScheduleTime: t.AdjustedScheduleTime,
+		AdjustmentAge:        t.AdjustmentAge,
+		BusType:              t.BusType,
+	}
+	if t.LastTripOfSchedule.Set {
+		p.LastTripOfSchedule = &t.LastTripOfSchedule.Value
+	}
+	if t.Latitude.Set {
+		p.Latitude = &t.Latitude.Value
+	}
+	if t.Longitude.Set {
+		p.Longitude = &t.Longitude.Value
+	}
+	if t.GPSSpeed.Set {
+		p.GPSSpeed = &t.GPSSpeed.Value
+	}
+	return p
+}
+
 // LastTripOfSchedule stores both the data and if the data was set by the API
 type LastTripOfSchedule struct {
 	Set   bool
@@ -269,8 +1753,9 @@ type rawNextTripsForStop struct {
 			Text                      string `xml:",chardata"`
 			Xmlns                     string `xml:"xmlns,attr"`
 			GetNextTripsForStopResult struct {
-				Text   string `xml:",chardata"`
-				StopNo struct {
+				Text      string `xml:",chardata"`
+				ErrorAttr string `xml:"error,attr"`
+				StopNo    struct {
 					Text  string `xml:",chardata"`
 					Xmlns string `xml:"xmlns,attr"`
 				} `xml:"StopNo"`
@@ -317,13 +1802,16 @@ type rawXMLTrip struct {
 }
 
 // Cook takes a raw XML NextTripsForStop and simplifies it.
-func (d *rawNextTripsForStop) cook() (*NextTripsForStop, error) {
+// The RequestProcessingTime of each route direction is parsed in the location tz.
+// If lenient, optional trip fields which can't be parsed are recorded in Warnings.
+func (d *rawNextTripsForStop) cook(tz *time.Location, lenient bool, maintenance []string) (*NextTripsForStop, error) {
 	cooked := &NextTripsForStop{}
 
 	cooked.StopNo = d.Body.GetNextTripsForStopResponse.GetNextTripsForStopResult.StopNo.Text
 	cooked.StopLabel = d.Body.GetNextTripsForStopResponse.GetNextTripsForStopResult.StopLabel.Text
 
-	errorText, err := checkErrorCode(d.Body.GetNextTripsForStopResponse.GetNextTripsForStopResult.Error.Text)
+	result := d.Body.GetNextTripsForStopResponse.GetNextTripsForStopResult
+	errorText, err := checkErrorCode(resultError(result.Error.Text, result.ErrorAttr), maintenance)
 	if err != nil {
 		return nil, err
 	}
@@ -335,17 +1823,12 @@ func (d *rawNextTripsForStop) cook() (*NextTripsForStop, error) {
 		crd.RouteLabel = rd.RouteLabel
 		crd.Direction = rd.Direction
 
-		errorText, err := checkErrorCode(rd.Error)
+		errorText, err := checkErrorCode(rd.Error, maintenance)
 		if err != nil {
 			return nil, err
 		}
 		crd.Error = errorText
 
-		tz, err := time.LoadLocation("America/Toronto")
-		if err != nil {
-			return nil, err
-		}
-
 		parsedProcessingTime, err := time.ParseInLocation("20060102150405", rd.RequestProcessingTime, tz)
 		if err != nil {
 			return nil, err
@@ -354,7 +1837,7 @@ func (d *rawNextTripsForStop) cook() (*NextTripsForStop, error) {
 		crd.RequestProcessingTime = parsedProcessingTime
 
 		for _, t := range rd.Trips.Trip {
-			ct, err := t.convert()
+			ct, err := t.convert(lenientWarn(lenient, &cooked.Warnings, rd.RouteNo, t.TripStartTime))
 			if err != nil {
 				return nil, err
 			}
@@ -366,42 +1849,191 @@ func (d *rawNextTripsForStop) cook() (*NextTripsForStop, error) {
 }
 
 // GetNextTripsForStop returns the next three trips on the route for a given stop number.
-func (c Connection) GetNextTripsForStop(ctx context.Context, routeNo, stopNo string) (*NextTripsForStop, error) {
+// The API has no parameter to request more trips, see GetMoreNextTripsForStop.
+// Options, such as Param, can be passed to add values to the request.
+func (c Connection) GetNextTripsForStop(ctx context.Context, routeNo, stopNo string, options ...RequestOption) (*NextTripsForStop, error) {
 	u, err := url.Parse(c.cAPIURLPrefix + "GetNextTripsForStop")
 	if err != nil {
-		return nil, err
+		return nil, c.prefixError(err)
 	}
 	v := url.Values{}
 	v.Set("appID", c.ID)
 	v.Set("apiKey", c.Key)
-	v.Set("routeNo", routeNo)
+	v.Set("routeNo", c.routeNo(routeNo))
 	v.Set("stopNo", stopNo)
+	settings, err := applyOptions(v, options)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
 
-	respBody, err := c.performRequest(ctx, *u, v)
+	respBody, err := c.performRequest(ctx, *u, v, settings)
 	if err != nil {
 		return nil, err
 	}
 
-	dec := xml.NewDecoder(respBody)
-	dec.CharsetReader = charset.NewReaderLabel
-	dec.Strict = false
 	data := &rawNextTripsForStop{}
-	err = dec.Decode(data)
+	err = decodeSOAP(respBody, data)
 	respBody.Close()
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+
+	tz, err := c.timeLocation()
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	cooked, err := data.cook(tz, c.lenient, c.maintenance)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	if c.strictStops && cooked.StopLabel == "" && len(cooked.RouteDirections) == 0 {
+		return nil, c.prefixError(ErrInvalidStop)
+	}
+	if c.staleThreshold > 0 {
+		now := time.Now()
+		for i, rd := range cooked.RouteDirections {
+			cooked.RouteDirections[i].Stale = rd.Staleness(now) > c.staleThreshold
+		}
+	}
+	cooked.Timing = c.timing(respBody)
+	return cooked, nil
+}
+
+// AsAllRoutes converts the trips into the shape returned by GetNextTripsForStopAllRoutes.
+// StopLabel becomes StopDescription, and each RouteLabel becomes a RouteHeading.
+// The Error and RequestProcessingTime of each route direction are lost, and
+// DirectionID is left empty, since GetNextTripsForStop doesn't return it.
+func (n *NextTripsForStop) AsAllRoutes() *NextTripsForStopAllRoutes {
+	converted := &NextTripsForStopAllRoutes{
+		StopNo:          n.StopNo,
+		StopDescription: n.StopLabel,
+		Error:           n.Error,
+	}
+	for _, rd := range n.RouteDirections {
+		converted.Routes = append(converted.Routes, RouteWithTrips{
+			RouteNo:      rd.RouteNo,
+			Direction:    rd.Direction,
+			RouteHeading: rd.RouteLabel,
+			Trips:        rd.Trips,
+		})
+	}
+	return converted
+}
+
+// GetMoreNextTripsForStop returns the next trips on the route for a given stop number,
+// merging the results of GetNextTripsForStop and GetNextTripsForStopAllRoutes.
+// The API doesn't support requesting a number of trips, and each endpoint returns
+// at most three trips per route direction. The two endpoints don't always return
+// the same trips though, so merging them can surface more than three.
+// Trips are deduplicated on destination and start time, and sorted by AdjustedScheduleTime.
+// This makes two requests to the API.
+func (c Connection) GetMoreNextTripsForStop(ctx context.Context, routeNo, stopNo string) (*NextTripsForStop, error) {
+	nextTrips, err := c.GetNextTripsForStop(ctx, routeNo, stopNo)
+	if err != nil {
+		return nil, err
+	}
+	allRoutes, err := c.GetNextTripsForStopAllRoutes(ctx, stopNo)
 	if err != nil {
 		return nil, err
 	}
 
-	return data.cook()
+	for i, rd := range nextTrips.RouteDirections {
+		for _, rt := range allRoutes.Routes {
+			if rt.RouteNo != rd.RouteNo || rt.Direction != rd.Direction {
+				continue
+			}
+			for _, t := range rt.Trips {
+				if !containsTrip(rd.Trips, t) {
+					rd.Trips = append(rd.Trips, t)
+				}
+			}
+		}
+		sort.SliceStable(rd.Trips, func(a, b int) bool {
+			return rd.Trips[a].AdjustedScheduleTime < rd.Trips[b].AdjustedScheduleTime
+		})
+		nextTrips.RouteDirections[i] = rd
+	}
+	return nextTrips, nil
+}
+
+func containsTrip(trips []Trip, t Trip) bool {
+	for _, e := range trips {
+		if e.TripDestination == t.TripDestination && e.TripStartTime == t.TripStartTime {
+			return true
+		}
+	}
+	return false
+}
+
+// TripsOnRoute returns the upcoming trips on a route at any of the stops, for example
+// to show every bus on the route. The API only returns trips for a stop, so this makes
+// a GetNextTripsForStop request for each stop, at once, and merges the results.
+// Trips seen at several stops, matched on direction, destination and start time,
+// are only returned once, as they were seen at the earliest stop in stopNos.
+// With WithPartialOnTimeout, the trips at the stops which responded before the
+// context's deadline are returned along with the error.
+func (c Connection) TripsOnRoute(ctx context.Context, routeNo string, stopNos []string) ([]TripWithRoute, error) {
+	results := make([]*NextTripsForStop, len(stopNos))
+	fns := make([]func(context.Context) error, len(stopNos))
+	for i, stopNo := range stopNos {
+		i, stopNo := i, stopNo
+		fns[i] = func(ctx context.Context) (err error) {
+			results[i], err = c.GetNextTripsForStop(ctx, routeNo, stopNo)
+			return err
+		}
+	}
+	errs, failed := c.fanOut(ctx, fns...)
+	if failed != nil {
+		return nil, failed
+	}
+
+	var trips []TripWithRoute
+	seen := make(map[[3]string]bool)
+	missing := 0
+	for i, nextTrips := range results {
+		if c.timedOut(errs[i]) {
+			missing++
+			continue
+		}
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		for _, rd := range nextTrips.RouteDirections {
+			for _, t := range rd.Trips {
+				key := [3]string{rd.Direction, t.TripDestination, t.TripStartTime}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				trips = append(trips, TripWithRoute{
+					RouteNo:      rd.RouteNo,
+					Direction:    rd.Direction,
+					RouteHeading: rd.RouteLabel,
+					Trip:         t,
+				})
+			}
+		}
+	}
+	if missing > 0 {
+		return trips, c.partialError(missing, len(stopNos))
+	}
+	return trips, nil
 }
 
 // NextTripsForStopAllRoutes is a simplified version of the data returned by
-// a request to GetNextTripsForStopAllRoutes
+// a request to GetNextTripsForStopAllRoutes.
+// A stop with no active routes, such as a decommissioned stop, is returned
+// with an empty Routes slice and an empty Error, rather than as an error.
+// Use ServesAnyRoute to tell that case apart.
 type NextTripsForStopAllRoutes struct {
 	StopNo          string
 	StopDescription string
 	Error           string
 	Routes          []RouteWithTrips
+	// Timing is set when the connection has WithCaptureTiming.
+	Timing *RequestInfo
+	// Warnings lists optional trip fields which couldn't be parsed, with WithLenientParsing.
+	Warnings []ParseWarning
 }
 
 // RouteWithTrips is used by NextTripsForStopAllRoutes to store route data.
@@ -413,8 +2045,38 @@ type RouteWithTrips struct {
 	Trips        []Trip
 }
 
+// TripsWithin returns the trips which are expected within minutes.
+func (rt RouteWithTrips) TripsWithin(minutes int) []Trip {
+	return tripsWithin(rt.Trips, minutes)
+}
+
+// HasAnyPosition returns true if any of the route's trips has a position.
+// A route with trips but no positions at all, such as the O-Train, which doesn't
+// report GPS, is likely scheduled-only, so a map layer for it can be hidden.
+func (rt RouteWithTrips) HasAnyPosition() bool {
+	for _, t := range rt.Trips {
+		if t.HasPosition() {
+			return true
+		}
+	}
+	return false
+}
+
+func tripsWithin(trips []Trip, minutes int) []Trip {
+	var within []Trip
+	for _, t := range trips {
+		if t.AdjustedScheduleTime <= minutes {
+			within = append(within, t)
+		}
+	}
+	return within
+}
+
 // NextTripsForStopAllRoutes is a wrapper around the XML data returned by
 // a request to GetNextTripsForStopAllRoutes.
+// The API reuses the element names of GetRouteSummaryForStop for the response and result,
+// but GetNextTripsForStopAllRoutesResponse and GetNextTripsForStopAllRoutesResult are
+// also accepted, in case the API is ever changed to use them.
 type rawNextTripsForStopAllRoutes struct {
 	XMLName xml.Name `xml:"Envelope"`
 	Text    string   `xml:",chardata"`
@@ -424,56 +2086,76 @@ type rawNextTripsForStopAllRoutes struct {
 	Body    struct {
 		Text                           string `xml:",chardata"`
 		GetRouteSummaryForStopResponse struct {
-			Text                         string `xml:",chardata"`
-			Xmlns                        string `xml:"xmlns,attr"`
-			GetRouteSummaryForStopResult struct {
-				Text   string `xml:",chardata"`
-				StopNo struct {
-					Text  string `xml:",chardata"`
-					Xmlns string `xml:"xmlns,attr"`
-				} `xml:"StopNo"`
-				StopDescription struct {
-					Text  string `xml:",chardata"`
-					Xmlns string `xml:"xmlns,attr"`
-				} `xml:"StopDescription"`
-				Error struct {
-					Text  string `xml:",chardata"`
-					Xmlns string `xml:"xmlns,attr"`
-				} `xml:"Error"`
-				Routes struct {
-					Text  string `xml:",chardata"`
-					Xmlns string `xml:"xmlns,attr"`
-					Route []struct {
-						Text         string `xml:",chardata"`
-						RouteNo      string `xml:"RouteNo"`
-						DirectionID  string `xml:"DirectionID"`
-						Direction    string `xml:"Direction"`
-						RouteHeading string `xml:"RouteHeading"`
-						Trips        struct {
-							Text string       `xml:",chardata"`
-							Trip []rawXMLTrip `xml:"Trip"`
-						} `xml:"Trips"`
-					} `xml:"Route"`
-				} `xml:"Routes"`
-			} `xml:"GetRouteSummaryForStopResult"`
+			Text                         string                             `xml:",chardata"`
+			Xmlns                        string                             `xml:"xmlns,attr"`
+			GetRouteSummaryForStopResult rawNextTripsForStopAllRoutesResult `xml:"GetRouteSummaryForStopResult"`
 		} `xml:"GetRouteSummaryForStopResponse"`
+		GetNextTripsForStopAllRoutesResponse *struct {
+			Text                               string                             `xml:",chardata"`
+			Xmlns                              string                             `xml:"xmlns,attr"`
+			GetNextTripsForStopAllRoutesResult rawNextTripsForStopAllRoutesResult `xml:"GetNextTripsForStopAllRoutesResult"`
+		} `xml:"GetNextTripsForStopAllRoutesResponse"`
 	} `xml:"Body"`
 }
 
+// rawNextTripsForStopAllRoutesResult is the result of a request to GetNextTripsForStopAllRoutes,
+// whichever element name it was returned in.
+type rawNextTripsForStopAllRoutesResult struct {
+	Text      string `xml:",chardata"`
+	ErrorAttr string `xml:"error,attr"`
+	StopNo    struct {
+		Text  string `xml:",chardata"`
+		Xmlns string `xml:"xmlns,attr"`
+	} `xml:"StopNo"`
+	StopDescription struct {
+		Text  string `xml:",chardata"`
+		Xmlns string `xml:"xmlns,attr"`
+	} `xml:"StopDescription"`
+	Error struct {
+		Text  string `xml:",chardata"`
+		Xmlns string `xml:"xmlns,attr"`
+	} `xml:"Error"`
+	Routes struct {
+		Text  string `xml:",chardata"`
+		Xmlns string `xml:"xmlns,attr"`
+		Route []struct {
+			Text         string `xml:",chardata"`
+			RouteNo      string `xml:"RouteNo"`
+			DirectionID  string `xml:"DirectionID"`
+			Direction    string `xml:"Direction"`
+			RouteHeading string `xml:"RouteHeading"`
+			Trips        struct {
+				Text string       `xml:",chardata"`
+				Trip []rawXMLTrip `xml:"Trip"`
+			} `xml:"Trips"`
+		} `xml:"Route"`
+	} `xml:"Routes"`
+}
+
+// result returns the result, from whichever response element the API used.
+func (d *rawNextTripsForStopAllRoutes) result() *rawNextTripsForStopAllRoutesResult {
+	if d.Body.GetNextTripsForStopAllRoutesResponse != nil {
+		return &d.Body.GetNextTripsForStopAllRoutesResponse.GetNextTripsForStopAllRoutesResult
+	}
+	return &d.Body.GetRouteSummaryForStopResponse.GetRouteSummaryForStopResult
+}
+
 // Cook takes a raw XML NextTripsForStopAllRoutes and simplifies it.
-func (d *rawNextTripsForStopAllRoutes) cook() (*NextTripsForStopAllRoutes, error) {
+// If lenient, optional trip fields which can't be parsed are recorded in Warnings.
+func (d *rawNextTripsForStopAllRoutes) cook(lenient bool, maintenance []string) (*NextTripsForStopAllRoutes, error) {
 	cooked := &NextTripsForStopAllRoutes{}
+	result := d.result()
 
-	cooked.StopNo = d.Body.GetRouteSummaryForStopResponse.GetRouteSummaryForStopResult.StopNo.Text
-	cooked.StopDescription = d.Body.GetRouteSummaryForStopResponse.GetRouteSummaryForStopResult.StopDescription.Text
+	cooked.StopNo = result.StopNo.Text
+	cooked.StopDescription = result.StopDescription.Text
 
-	errorText, err := checkErrorCode(d.Body.GetRouteSummaryForStopResponse.GetRouteSummaryForStopResult.Error.Text)
+	errorText, err := checkErrorCode(resultError(result.Error.Text, result.ErrorAttr), maintenance)
 	if err != nil {
 		return nil, err
 	}
 	cooked.Error = errorText
 
-	for _, rt := range d.Body.GetRouteSummaryForStopResponse.GetRouteSummaryForStopResult.Routes.Route {
+	for _, rt := range result.Routes.Route {
 		crt := RouteWithTrips{}
 		crt.RouteNo = rt.RouteNo
 		crt.DirectionID = rt.DirectionID
@@ -481,7 +2163,7 @@ func (d *rawNextTripsForStopAllRoutes) cook() (*NextTripsForStopAllRoutes, error
 		crt.RouteHeading = rt.RouteHeading
 
 		for _, t := range rt.Trips.Trip {
-			ct, err := t.convert()
+			ct, err := t.convert(lenientWarn(lenient, &cooked.Warnings, rt.RouteNo, t.TripStartTime))
 			if err != nil {
 				return nil, err
 			}
@@ -493,42 +2175,492 @@ func (d *rawNextTripsForStopAllRoutes) cook() (*NextTripsForStopAllRoutes, error
 }
 
 // GetNextTripsForStopAllRoutes returns the next three trips for all routes for a given stop number.
-func (c Connection) GetNextTripsForStopAllRoutes(ctx context.Context, stopNo string) (*NextTripsForStopAllRoutes, error) {
+// Options, such as Param, can be passed to add values to the request.
+func (c Connection) GetNextTripsForStopAllRoutes(ctx context.Context, stopNo string, options ...RequestOption) (*NextTripsForStopAllRoutes, error) {
 	u, err := url.Parse(c.cAPIURLPrefix + "GetNextTripsForStopAllRoutes")
 	if err != nil {
-		return nil, err
+		return nil, c.prefixError(err)
 	}
 	v := url.Values{}
 	v.Set("appID", c.ID)
 	v.Set("apiKey", c.Key)
 	v.Set("stopNo", stopNo)
+	settings, err := applyOptions(v, options)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
 
-	respBody, err := c.performRequest(ctx, *u, v)
+	respBody, err := c.performRequest(ctx, *u, v, settings)
 	if err != nil {
 		return nil, err
 	}
 
-	dec := xml.NewDecoder(respBody)
-	dec.CharsetReader = charset.NewReaderLabel
-	dec.Strict = false
 	data := &rawNextTripsForStopAllRoutes{}
-	err = dec.Decode(data)
+	err = decodeSOAP(respBody, data)
 	respBody.Close()
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+
+	cooked, err := data.cook(c.lenient, c.maintenance)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	if c.strictStops && cooked.StopDescription == "" && len(cooked.Routes) == 0 {
+		return nil, c.prefixError(ErrInvalidStop)
+	}
+	cooked.Timing = c.timing(respBody)
+	return cooked, nil
+}
+
+// AsNextTripsForStop converts the trips into the shape returned by GetNextTripsForStop.
+// StopDescription becomes StopLabel, and each RouteHeading becomes a RouteLabel.
+// DirectionID is lost, and the Error and RequestProcessingTime of each
+// route direction are left empty, since GetNextTripsForStopAllRoutes doesn't return them.
+func (n *NextTripsForStopAllRoutes) AsNextTripsForStop() *NextTripsForStop {
+	converted := &NextTripsForStop{
+		StopNo:    n.StopNo,
+		StopLabel: n.StopDescription,
+		Error:     n.Error,
+	}
+	for _, rt := range n.Routes {
+		converted.RouteDirections = append(converted.RouteDirections, RouteDirection{
+			RouteNo:    rt.RouteNo,
+			RouteLabel: rt.RouteHeading,
+			Direction:  rt.Direction,
+			Trips:      rt.Trips,
+		})
+	}
+	return converted
+}
+
+// Bounds returns the bounding box of the trips on all routes which have a position,
+// for framing them on a map. ok is false when none of the trips have a position.
+func (n *NextTripsForStopAllRoutes) Bounds() (minLat, minLon, maxLat, maxLon float64, ok bool) {
+	var trips []Trip
+	for _, rt := range n.Routes {
+		trips = append(trips, rt.Trips...)
+	}
+	return tripBounds(trips)
+}
+
+// TripWithRoute is a trip along with the route it's on.
+type TripWithRoute struct {
+	RouteNo      string
+	DirectionID  string
+	Direction    string
+	RouteHeading string
+	Trip
+}
+
+// ByDestination returns the trips on all routes grouped by TripDestination.
+// The trips for each destination keep the order they were returned in by the API.
+func (n *NextTripsForStopAllRoutes) ByDestination() map[string][]TripWithRoute {
+	grouped := make(map[string][]TripWithRoute)
+	for _, rt := range n.Routes {
+		for _, t := range rt.Trips {
+			grouped[t.TripDestination] = append(grouped[t.TripDestination], TripWithRoute{
+				RouteNo:      rt.RouteNo,
+				DirectionID:  rt.DirectionID,
+				Direction:    rt.Direction,
+				RouteHeading: rt.RouteHeading,
+				Trip:         t,
+			})
+		}
+	}
+	return grouped
+}
+
+// RouteDeparture is the next departure on a route, returned by NextDepartureSummary.
+type RouteDeparture struct {
+	RouteNo      string
+	Direction    string
+	RouteHeading string
+	MinutesAway  int
+	RealTime     bool
+}
+
+// NextDepartureSummary returns the soonest trip for each route number, across its directions,
+// in the order the routes were returned by the API. Routes without trips are left out.
+func (n *NextTripsForStopAllRoutes) NextDepartureSummary() []RouteDeparture {
+	var summary []RouteDeparture
+	index := make(map[string]int)
+	for _, rt := range n.Routes {
+		for _, t := range rt.Trips {
+			d := RouteDeparture{
+				RouteNo:      rt.RouteNo,
+				Direction:    rt.Direction,
+				RouteHeading: rt.RouteHeading,
+				MinutesAway:  t.AdjustedScheduleTime,
+				RealTime:     t.IsRealTime(),
+			}
+			i, ok := index[rt.RouteNo]
+			if !ok {
+				index[rt.RouteNo] = len(summary)
+				summary = append(summary, d)
+			} else if d.MinutesAway < summary[i].MinutesAway {
+				summary[i] = d
+			}
+		}
+	}
+	return summary
+}
+
+// BoardRow is a row of a departure board, as returned by Board.
+type BoardRow struct {
+	RouteNo     string
+	Destination string
+	MinutesAway int
+	RealTime    bool
+}
+
+// Board returns the trips of all the routes as rows of a departure board,
+// soonest first, capped at max rows. If max is 0 or less, every trip is returned.
+func (n *NextTripsForStopAllRoutes) Board(max int) []BoardRow {
+	var rows []BoardRow
+	for _, rt := range n.Routes {
+		for _, t := range rt.Trips {
+			rows = append(rows, BoardRow{
+				RouteNo:     rt.RouteNo,
+				Destination: t.TripDestination,
+				MinutesAway: t.AdjustedScheduleTime,
+				RealTime:    t.IsRealTime(),
+			})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].MinutesAway < rows[j].MinutesAway
+	})
+	if max > 0 && len(rows) > max {
+		rows = rows[:max]
+	}
+	return rows
+}
+
+// ServesAnyRoute returns true if at least one route was returned for the stop.
+func (n *NextTripsForStopAllRoutes) ServesAnyRoute() bool {
+	return len(n.Routes) > 0
+}
+
+// HasImminentArrival returns true if any trip, on any route, is expected
+// within threshold minutes.
+func (n *NextTripsForStopAllRoutes) HasImminentArrival(threshold int) bool {
+	for _, rt := range n.Routes {
+		if len(rt.TripsWithin(threshold)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// BusTypes returns the distinct BusType values of the trips, across all routes,
+// trimmed of surrounding whitespace, in the order they were first seen.
+// Trips without a BusType are left out.
+func (n *NextTripsForStopAllRoutes) BusTypes() []string {
+	var busTypes []string
+	seen := make(map[string]bool)
+	for _, rt := range n.Routes {
+		for _, t := range rt.Trips {
+			busType := strings.TrimSpace(t.BusType)
+			if busType == "" || seen[busType] {
+				continue
+			}
+			seen[busType] = true
+			busTypes = append(busTypes, busType)
+		}
+	}
+	return busTypes
+}
+
+// RouteServesDestination returns true if any trip on the route, in either direction,
+// has a TripDestination containing destinationSubstring, ignoring case.
+func (n *NextTripsForStopAllRoutes) RouteServesDestination(routeNo, destinationSubstring string) bool {
+	want := strings.ToLower(destinationSubstring)
+	for _, rt := range n.Routes {
+		if rt.RouteNo != routeNo {
+			continue
+		}
+		for _, t := range rt.Trips {
+			if strings.Contains(strings.ToLower(t.TripDestination), want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RouteCard groups the directions of a route at a stop, such as route 97's
+// Eastbound to the Airport and Westbound to Bells Corners, for showing together.
+type RouteCard struct {
+	RouteNo    string
+	Directions []RouteWithTrips
+}
+
+// RouteCards groups the routes by route number, with a card for each route
+// holding its directions, in the order the routes were returned by the API.
+func (n *NextTripsForStopAllRoutes) RouteCards() []RouteCard {
+	var cards []RouteCard
+	index := make(map[string]int)
+	for _, rt := range n.Routes {
+		i, ok := index[rt.RouteNo]
+		if !ok {
+			i = len(cards)
+			index[rt.RouteNo] = i
+			cards = append(cards, RouteCard{RouteNo: rt.RouteNo})
+		}
+		cards[i].Directions = append(cards[i].Directions, rt)
+	}
+	return cards
+}
+
+// NextTripsRequest builds a request for the next trips at a stop with chainable methods,
+// as an alternative to filtering the result of GetNextTripsForStopAllRoutes by hand.
+// It's created with Connection.NextTrips.
+type NextTripsRequest struct {
+	c         Connection
+	stopNo    string
+	routeNo   string
+	direction string
+	within    int
+	options   []RequestOption
+}
+
+// NextTrips starts a request for the next trips at a stop, which is made by calling Do.
+func (c Connection) NextTrips(stopNo string) *NextTripsRequest {
+	return &NextTripsRequest{c: c, stopNo: stopNo, within: -1}
+}
+
+// Route only keeps the trips on the route.
+func (r *NextTripsRequest) Route(routeNo string) *NextTripsRequest {
+	r.routeNo = routeNo
+	return r
+}
+
+// Direction only keeps the trips in a direction, such as "Westbound" or "W",
+// matched with CanonicalDirection.
+func (r *NextTripsRequest) Direction(direction string) *NextTripsRequest {
+	r.direction = direction
+	return r
+}
+
+// Within only keeps the trips which are expected within minutes.
+func (r *NextTripsRequest) Within(minutes int) *NextTripsRequest {
+	r.within = minutes
+	return r
+}
+
+// Options adds request options, such as Param, to the request.
+func (r *NextTripsRequest) Options(options ...RequestOption) *NextTripsRequest {
+	r.options = append(r.options, options...)
+	return r
+}
+
+// Do makes the request with GetNextTripsForStopAllRoutes, and returns the result
+// with only the matching routes and trips.
+func (r *NextTripsRequest) Do(ctx context.Context) (*NextTripsForStopAllRoutes, error) {
+	nextTrips, err := r.c.GetNextTripsForStopAllRoutes(ctx, r.stopNo, r.options...)
 	if err != nil {
 		return nil, err
 	}
+	var routes []RouteWithTrips
+	for _, rt := range nextTrips.Routes {
+		if r.routeNo != "" && r.c.routeNo(rt.RouteNo) != r.c.routeNo(r.routeNo) {
+			continue
+		}
+		if r.direction != "" && !sameDirection(rt.Direction, r.direction) {
+			continue
+		}
+		if r.within >= 0 {
+			rt.Trips = rt.TripsWithin(r.within)
+		}
+		routes = append(routes, rt)
+	}
+	nextTrips.Routes = routes
+	return nextTrips, nil
+}
+
+// sameDirection compares directions with CanonicalDirection,
+// or ignoring case if either isn't recognized.
+func sameDirection(a, b string) bool {
+	ca, cb := CanonicalDirection(a), CanonicalDirection(b)
+	if ca != "" && cb != "" {
+		return ca == cb
+	}
+	return strings.EqualFold(a, b)
+}
+
+// StopScheduleURL returns the address of OC Transpo's web schedule for the stop,
+// for linking to the official schedule.
+func (c Connection) StopScheduleURL(stopNo string) string {
+	v := url.Values{}
+	v.Set("sched-type", "stop")
+	v.Set("stop", stopNo)
+	return ScheduleURLPrefix + "?" + v.Encode()
+}
+
+// RouteScheduleURL returns the address of OC Transpo's web schedule for the route,
+// for linking to the official schedule.
+func (c Connection) RouteScheduleURL(routeNo string) string {
+	v := url.Values{}
+	v.Set("sched-type", "route")
+	v.Set("route", routeNo)
+	return ScheduleURLPrefix + "?" + v.Encode()
+}
+
+// CanonicalDirection maps a direction string, such as "Northbound", "northbound",
+// "NB", or "Nord", to one of "N", "S", "E" or "W".
+// An empty string is returned if the direction isn't recognized.
+func CanonicalDirection(s string) string {
+	d := strings.ToLower(strings.TrimSpace(s))
+	d = strings.TrimSuffix(d, "bound")
+	d = strings.TrimSuffix(d, " ")
+	switch d {
+	case "n", "nb", "north", "nord":
+		return "N"
+	case "s", "sb", "south", "sud":
+		return "S"
+	case "e", "eb", "east", "est":
+		return "E"
+	case "w", "wb", "west", "o", "ouest":
+		return "W"
+	default:
+		return ""
+	}
+}
+
+// reservedParams are the parameters set by the methods themselves,
+// which Param can't overwrite.
+var reservedParams = map[string]bool{
+	"appID":   true,
+	"apiKey":  true,
+	"format":  true,
+	"table":   true,
+	"stopNo":  true,
+	"routeNo": true,
+}
+
+// Param will setup the request to include an arbitrary parameter,
+// for parameters the API supports which don't have their own option.
+// It can't be used to overwrite the parameters the methods set themselves,
+// such as the appID, apiKey, stopNo or routeNo.
+func Param(key, value string) RequestOption {
+	return func(r *requestSetup) error {
+		if reservedParams[key] {
+			return fmt.Errorf("the %v parameter is reserved", key)
+		}
+		r.values.Set(key, value)
+		return nil
+	}
+}
+
+// WithCredentials will setup the request to use an appID and apiKey other than
+// the connection's, for that request only, for services holding several keys.
+func WithCredentials(id, key string) RequestOption {
+	return func(r *requestSetup) error {
+		r.values.Set("appID", id)
+		r.values.Set("apiKey", key)
+		return nil
+	}
+}
+
+// noRateLimitKey marks a request's context to skip the rate limiter.
+type noRateLimitKey struct{}
+
+// WithoutRateLimit will setup the request to skip waiting for the connection's
+// rate limiter, for urgent requests which shouldn't wait behind a background poller.
+// This is dangerous: requests made with it still count towards the API's daily quota,
+// and aren't counted by the limiter, so overuse can exceed the quota.
+func WithoutRateLimit() RequestOption {
+	return func(r *requestSetup) error {
+		r.settings.noRateLimit = true
+		return nil
+	}
+}
+
+// RequestOption sets up a single request, such as ColumnAndValue or Param.
+type RequestOption func(r *requestSetup) error
+
+// requestSetup is what the options of a request set up: the query parameters
+// sent to the API, and the settings of the request which aren't.
+type requestSetup struct {
+	values   url.Values
+	settings *requestSettings
+}
+
+// requestSettings are the settings of a single request which aren't sent to the API,
+// such as the range of rows kept by ColumnRange.
+type requestSettings struct {
+	columnRange *columnRange
+	fields      []string
+	noRateLimit bool
+}
+
+// context returns the context to make the request with, marked to skip
+// the rate limiter if WithoutRateLimit was passed.
+func (s *requestSettings) context(ctx context.Context) context.Context {
+	if !s.noRateLimit {
+		return ctx
+	}
+	return context.WithValue(ctx, noRateLimitKey{}, true)
+}
+
+// applyOptions applies the options to the values of a request, returning the
+// settings of the request which the options set, other than its values.
+func applyOptions(v url.Values, options []RequestOption) (*requestSettings, error) {
+	r := &requestSetup{values: v, settings: &requestSettings{}}
+	for _, opt := range options {
+		err := opt(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.settings, nil
+}
 
-	return data.cook()
+// ErrInvalidStop is returned when the API reports an invalid stop number,
+// or with WithStrictStopResolution, when a stop has no description and no routes.
+var ErrInvalidStop = errors.New("error returned from API - Invalid stop number")
+
+// ErrServiceMaintenance is returned when the API reports it's down for maintenance,
+// with a maintenance message in place of an error code, see WithMaintenanceMessages.
+// Callers should back off for longer than for other errors.
+var ErrServiceMaintenance = errors.New("error returned from API - Service maintenance")
+
+// defaultMaintenanceMessages are the messages the API is known to put in the Error field
+// while it's down for maintenance.
+var defaultMaintenanceMessages = []string{
+	"maintenance",
+	"service is temporarily unavailable",
+	"service unavailable",
+}
+
+// resultError returns the error of a result, from its Error element, or if that's
+// empty, its error attribute, which some SOAP gateways use instead.
+func resultError(element, attr string) string {
+	if element != "" {
+		return element
+	}
+	return attr
 }
 
-func checkErrorCode(errorText string) (string, error) {
+// checkErrorCode returns the error for an error code, or ErrServiceMaintenance if
+// the error text contains one of the default maintenance messages, or of maintenance.
+func checkErrorCode(errorText string, maintenance []string) (string, error) {
+	lower := strings.ToLower(errorText)
+	for _, messages := range [][]string{defaultMaintenanceMessages, maintenance} {
+		for _, msg := range messages {
+			if msg != "" && strings.Contains(lower, strings.ToLower(msg)) {
+				return "", fmt.Errorf("%w: %v", ErrServiceMaintenance, errorText)
+			}
+		}
+	}
 	switch errorText {
 	case "1":
 		return "", errors.New("error returned from API - Invalid API key")
 	case "2":
 		return "", errors.New("error returned from API - Unable to query data source")
 	case "10":
-		return "", errors.New("error returned from API - Invalid stop number")
+		return "", ErrInvalidStop
 	case "11":
 		return "", errors.New("error returned from API - Invalid route number")
 	case "12":
@@ -538,7 +2670,26 @@ func checkErrorCode(errorText string) (string, error) {
 	}
 }
 
-func (t rawXMLTrip) convert() (Trip, error) {
+// lenientWarn returns a function for convert which appends a ParseWarning for the trip
+// to warnings, or nil if not lenient.
+func lenientWarn(lenient bool, warnings *[]ParseWarning, routeNo, tripStartTime string) func(field, value string, err error) {
+	if !lenient {
+		return nil
+	}
+	return func(field, value string, err error) {
+		*warnings = append(*warnings, ParseWarning{
+			RouteNo:       routeNo,
+			TripStartTime: tripStartTime,
+			Field:         field,
+			Value:         value,
+			Err:           err,
+		})
+	}
+}
+
+// convert parses the raw trip. If warn isn't nil, optional fields which can't be
+// parsed are passed to it and left unset, rather than returning an error.
+func (t rawXMLTrip) convert(warn func(field, value string, err error)) (Trip, error) {
 	ct := Trip{}
 	ct.TripDestination = t.TripDestination
 	ct.TripStartTime = t.TripStartTime
@@ -560,9 +2711,13 @@ func (t rawXMLTrip) convert() (Trip, error) {
 	} else {
 		pLastTripOfSchedule, err := strconv.ParseBool(t.LastTripOfSchedule)
 		if err != nil {
-			return ct, err
+			if warn == nil {
+				return ct, err
+			}
+			warn("LastTripOfSchedule", t.LastTripOfSchedule, err)
+		} else {
+			ct.LastTripOfSchedule = LastTripOfSchedule{Set: true, Value: pLastTripOfSchedule}
 		}
-		ct.LastTripOfSchedule = LastTripOfSchedule{Set: true, Value: pLastTripOfSchedule}
 	}
 
 	ct.BusType = t.BusType
@@ -572,9 +2727,13 @@ func (t rawXMLTrip) convert() (Trip, error) {
 	} else {
 		pLatitude, err := strconv.ParseFloat(t.Latitude, 64)
 		if err != nil {
-			return ct, err
+			if warn == nil {
+				return ct, err
+			}
+			warn("Latitude", t.Latitude, err)
+		} else {
+			ct.Latitude = Latitude{Set: true, Value: pLatitude}
 		}
-		ct.Latitude = Latitude{Set: true, Value: pLatitude}
 	}
 
 	if t.Longitude == "" {
@@ -582,9 +2741,13 @@ func (t rawXMLTrip) convert() (Trip, error) {
 	} else {
 		pLongitude, err := strconv.ParseFloat(t.Longitude, 64)
 		if err != nil {
-			return ct, err
+			if warn == nil {
+				return ct, err
+			}
+			warn("Longitude", t.Longitude, err)
+		} else {
+			ct.Longitude = Longitude{Set: true, Value: pLongitude}
 		}
-		ct.Longitude = Longitude{Set: true, Value: pLongitude}
 	}
 
 	if t.GPSSpeed == "" {
@@ -592,9 +2755,13 @@ func (t rawXMLTrip) convert() (Trip, error) {
 	} else {
 		pGPSSpeed, err := strconv.ParseFloat(t.GPSSpeed, 64)
 		if err != nil {
-			return ct, err
+			if warn == nil {
+				return ct, err
+			}
+			warn("GPSSpeed", t.GPSSpeed, err)
+		} else {
+			ct.GPSSpeed = GPSSpeed{Set: true, Value: pGPSSpeed}
 		}
-		ct.GPSSpeed = GPSSpeed{Set: true, Value: pGPSSpeed}
 	}
 
 	return ct, nil
@@ -2,9 +2,17 @@ package gooctranspoapi
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -129,6 +137,85 @@ func TestRouteSummaryForStopWithError(t *testing.T) {
 
 }
 
+func TestRouteSummaryForStopGroupByRoute(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">7659</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">BANK / FIFTH</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>6</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Northbound</Direction>
+            <RouteHeading>Rockcliffe</RouteHeading>
+          </Route>
+          <Route>
+            <RouteNo>7</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>St-Laurent</RouteHeading>
+          </Route>
+          <Route>
+            <RouteNo>6</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Southbound</Direction>
+            <RouteHeading>Greenboro</RouteHeading>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	routeSummary, err := c.GetRouteSummaryForStop(context.TODO(), "7659")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grouped := routeSummary.GroupByRoute()
+	if len(grouped) != 2 {
+		t.Fatal("Unexpected number of route groups from GroupByRoute")
+	}
+	if len(grouped["6"]) != 2 {
+		t.Fatal("Unexpected number of directions for route 6 from GroupByRoute")
+	}
+	if grouped["6"][0].Direction != "Northbound" || grouped["6"][1].Direction != "Southbound" {
+		t.Fatal("Unexpected direction order for route 6 from GroupByRoute")
+	}
+	if len(grouped["7"]) != 1 || grouped["7"][0].RouteHeading != "St-Laurent" {
+		t.Fatal("Unexpected directions for route 7 from GroupByRoute")
+	}
+}
+
+func TestDirectionsForRoute(t *testing.T) {
+	routeSummary := &RouteSummaryForStop{StopNo: "7659", Routes: []Route{
+		{RouteNo: "6", DirectionID: "1", Direction: "Northbound", RouteHeading: "Rockcliffe"},
+		{RouteNo: "7", DirectionID: "1", Direction: "Eastbound", RouteHeading: "St-Laurent"},
+		{RouteNo: "6", DirectionID: "0", Direction: "Southbound", RouteHeading: "Greenboro"},
+	}}
+
+	directions := routeSummary.DirectionsForRoute("6")
+	if len(directions) != 2 || directions[0].Direction != "Northbound" || directions[1].Direction != "Southbound" {
+		t.Fatal("Unexpected directions for route 6 from DirectionsForRoute")
+	}
+	if routeSummary.DirectionsForRoute("95") != nil {
+		t.Fatal("Expected no directions for a route which doesn't serve the stop")
+	}
+}
+
 func TestGetNextTripsForStop(t *testing.T) {
 	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
 <soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
@@ -541,3 +628,3533 @@ func TestGetNextTripsForStopAllRoutes(t *testing.T) {
 	}
 
 }
+
+func TestGetNextTripsForStopAllRoutesEmptyRoutes(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">1234</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">CLOSED STOP</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nextTripsAllRoutes.Error != "" {
+		t.Fatal("Unexpected Error in returned NextTripsForStopAllRoutes")
+	}
+	if len(nextTripsAllRoutes.Routes) != 0 {
+		t.Fatal("Unexpected Routes in returned NextTripsForStopAllRoutes")
+	}
+	if nextTripsAllRoutes.ServesAnyRoute() {
+		t.Fatal("Expected ServesAnyRoute to be false for a stop with no routes")
+	}
+}
+
+func TestGetMoreNextTripsForStop(t *testing.T) {
+	rawNextTripsXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>97</RouteNo>
+            <RouteLabel>Airport / Aéroport</RouteLabel>
+            <Direction>Eastbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+              </Trip>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:29</TripStartTime>
+                <AdjustedScheduleTime>22</AdjustedScheduleTime>
+                <AdjustmentAge>-1</AdjustmentAge>
+              </Trip>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:44</TripStartTime>
+                <AdjustedScheduleTime>37</AdjustedScheduleTime>
+                <AdjustmentAge>-1</AdjustmentAge>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawAllRoutesXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+              </Trip>
+              <Trip>
+                <TripDestination>South Keys</TripDestination>
+                <TripStartTime>12:43</TripStartTime>
+                <AdjustedScheduleTime>23</AdjustedScheduleTime>
+                <AdjustmentAge>0.40</AdjustmentAge>
+              </Trip>
+            </Trips>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/GetNextTripsForStopAllRoutes" {
+			fmt.Fprint(w, rawAllRoutesXMLString)
+			return
+		}
+		fmt.Fprint(w, rawNextTripsXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTrips, err := c.GetMoreNextTripsForStop(context.TODO(), "97", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trips := nextTrips.RouteDirections[0].Trips
+	if len(trips) != 4 {
+		t.Fatalf("Unexpected number of merged trips from GetMoreNextTripsForStop: %v", len(trips))
+	}
+	expectedStartTimes := []string{"13:14", "13:29", "12:43", "13:44"}
+	for i, trip := range trips {
+		if trip.TripStartTime != expectedStartTimes[i] {
+			t.Fatal("Unexpected trip order from GetMoreNextTripsForStop")
+		}
+	}
+}
+
+func TestParam(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">7659</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">BANK / FIFTH</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	var form url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("testID", "testKey")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetRouteSummaryForStop(context.TODO(), "7659", Param("lang", "fr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("lang") != "fr" {
+		t.Fatal("Expected custom param in request")
+	}
+	if form.Get("apiKey") != "testKey" {
+		t.Fatal("Unexpected apiKey in request")
+	}
+
+	_, err = c.GetRouteSummaryForStop(context.TODO(), "7659", Param("apiKey", "otherKey"))
+	if err == nil {
+		t.Fatal("Expected error from Param overwriting apiKey")
+	}
+	_, err = c.GetRouteSummaryForStop(context.TODO(), "7659", Param("stopNo", "3020"))
+	if err == nil {
+		t.Fatal("Expected error from Param overwriting stopNo")
+	}
+	_, err = c.GetNextTripsForStop(context.TODO(), "6", "7659", Param("routeNo", "7"))
+	if err == nil {
+		t.Fatal("Expected error from Param overwriting routeNo")
+	}
+}
+
+func TestTorontoLocationFallback(t *testing.T) {
+	defer func(f func(string) (*time.Location, error)) { loadLocation = f }(loadLocation)
+	loadLocation = func(string) (*time.Location, error) {
+		return nil, errors.New("unknown time zone America/Toronto")
+	}
+
+	tz, err := torontoLocation()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, summerOffset := time.Date(2018, time.August, 31, 11, 40, 42, 0, tz).Zone()
+	if summerOffset != -4*60*60 {
+		t.Fatalf("Unexpected summer offset from fallback location: %v", summerOffset)
+	}
+	_, winterOffset := time.Date(2018, time.December, 31, 11, 40, 42, 0, tz).Zone()
+	if winterOffset != -5*60*60 {
+		t.Fatalf("Unexpected winter offset from fallback location: %v", winterOffset)
+	}
+}
+
+func TestWithMaxConcurrent(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">7659</StopNo>
+        <Error xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "", WithMaxConcurrent(2))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.GetRouteSummaryForStop(context.TODO(), "7659")
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight > 2 {
+		t.Fatalf("Unexpected number of requests in flight with WithMaxConcurrent(2): %v", maxInFlight)
+	}
+}
+
+func TestCanonicalDirection(t *testing.T) {
+	tests := map[string]string{
+		"Northbound":  "N",
+		"northbound":  "N",
+		"NORTH BOUND": "N",
+		"SB":          "S",
+		"Southbound":  "S",
+		" Eastbound ": "E",
+		"East":        "E",
+		"Westbound":   "W",
+		"Ouest":       "W",
+		"Sideways":    "",
+	}
+	for input, expected := range tests {
+		if CanonicalDirection(input) != expected {
+			t.Fatalf("Unexpected canonical direction for %q: %q", input, CanonicalDirection(input))
+		}
+	}
+}
+
+func TestWithDebug(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">7659</StopNo>
+        <Error xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	var logged []string
+	logf := func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	}
+	c := NewConnection("secretID", "secretKey", WithDebug(logf))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetRouteSummaryForStop(context.TODO(), "7659")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetGTFSAgency(context.TODO())
+	if err == nil {
+		t.Fatal("Expected error decoding XML as GTFS")
+	}
+
+	if len(logged) != 2 {
+		t.Fatalf("Unexpected number of logged requests: %v", len(logged))
+	}
+	for _, l := range logged {
+		if !strings.Contains(l, "apiKey=***") || !strings.Contains(l, "appID=***") {
+			t.Fatalf("Expected redacted credentials in logged request: %v", l)
+		}
+		if strings.Contains(l, "secretKey") || strings.Contains(l, "secretID") {
+			t.Fatalf("Unexpected credentials in logged request: %v", l)
+		}
+	}
+	if !strings.Contains(logged[0], "stopNo=7659") {
+		t.Fatalf("Expected stopNo in logged request: %v", logged[0])
+	}
+}
+
+type stubClient struct {
+	Client
+	stopDescription string
+}
+
+func (s stubClient) GetRouteSummaryForStop(ctx context.Context, stopNo string, options ...RequestOption) (*RouteSummaryForStop, error) {
+	return &RouteSummaryForStop{StopNo: stopNo, StopDescription: s.stopDescription}, nil
+}
+
+func TestClientStub(t *testing.T) {
+	describe := func(c Client, stopNo string) (string, error) {
+		summary, err := c.GetRouteSummaryForStop(context.TODO(), stopNo)
+		if err != nil {
+			return "", err
+		}
+		return summary.StopNo + " " + summary.StopDescription, nil
+	}
+
+	description, err := describe(stubClient{stopDescription: "BANK / FIFTH"}, "7659")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if description != "7659 BANK / FIFTH" {
+		t.Fatalf("Unexpected description from stub Client: %v", description)
+	}
+}
+
+func TestAsAllRoutesRoundTrip(t *testing.T) {
+	nextTrips := &NextTripsForStop{
+		StopNo:    "3020",
+		StopLabel: "LAURIER STATION",
+		RouteDirections: []RouteDirection{
+			{
+				RouteNo:               "94",
+				RouteLabel:            "Millennium",
+				Direction:             "Eastbound",
+				RequestProcessingTime: time.Date(2018, time.August, 31, 11, 40, 42, 0, time.UTC),
+				Trips: []Trip{
+					{TripDestination: "Millennium", TripStartTime: "11:00", AdjustedScheduleTime: 12},
+				},
+			},
+		},
+	}
+
+	allRoutes := nextTrips.AsAllRoutes()
+	if allRoutes.StopNo != "3020" || allRoutes.StopDescription != "LAURIER STATION" {
+		t.Fatal("Unexpected stop in NextTripsForStopAllRoutes from AsAllRoutes")
+	}
+	if allRoutes.Routes[0].RouteHeading != "Millennium" || allRoutes.Routes[0].Direction != "Eastbound" {
+		t.Fatal("Unexpected route in NextTripsForStopAllRoutes from AsAllRoutes")
+	}
+
+	roundTrip := allRoutes.AsNextTripsForStop()
+	if roundTrip.StopNo != nextTrips.StopNo || roundTrip.StopLabel != nextTrips.StopLabel {
+		t.Fatal("Unexpected stop in round tripped NextTripsForStop")
+	}
+	rd := roundTrip.RouteDirections[0]
+	if rd.RouteNo != "94" || rd.RouteLabel != "Millennium" || rd.Direction != "Eastbound" {
+		t.Fatal("Unexpected route direction in round tripped NextTripsForStop")
+	}
+	if rd.Trips[0] != nextTrips.RouteDirections[0].Trips[0] {
+		t.Fatal("Unexpected trip in round tripped NextTripsForStop")
+	}
+	if !rd.RequestProcessingTime.IsZero() {
+		t.Fatal("Expected RequestProcessingTime to be lost in round tripped NextTripsForStop")
+	}
+}
+
+func TestWithStaleThreshold(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Millennium</RouteLabel>
+            <Direction>Eastbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips/>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTrips, err := c.GetNextTripsForStop(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTrips.RouteDirections[0].Stale {
+		t.Fatal("Unexpected Stale route direction without a stale threshold")
+	}
+
+	rd := nextTrips.RouteDirections[0]
+	if rd.Staleness(rd.RequestProcessingTime.Add(90*time.Second)) != 90*time.Second {
+		t.Fatal("Unexpected Staleness of route direction")
+	}
+
+	c = NewConnection("", "", WithStaleThreshold(5*time.Minute))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTrips, err = c.GetNextTripsForStop(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nextTrips.RouteDirections[0].Stale {
+		t.Fatal("Expected Stale route direction with an old RequestProcessingTime")
+	}
+}
+
+func TestWithLocationFixedOffset(t *testing.T) {
+	// 02:30 on March 11, 2018 doesn't exist in America/Toronto, since clocks spring forward at 02:00.
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Millennium</RouteLabel>
+            <Direction>Eastbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180311023000</RequestProcessingTime>
+            <Trips/>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	beforeSpringForward, err := FixedTorontoOffset(time.Date(2018, time.March, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterSpringForward, err := FixedTorontoOffset(time.Date(2018, time.March, 12, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewConnection("", "", WithLocation(beforeSpringForward))
+	c.cAPIURLPrefix = ts.URL + "/"
+	nextTrips, err := c.GetNextTripsForStop(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2018, time.March, 11, 7, 30, 0, 0, time.UTC)
+	if !nextTrips.RouteDirections[0].RequestProcessingTime.Equal(expected) {
+		t.Fatalf("Unexpected RequestProcessingTime with EST offset: %v", nextTrips.RouteDirections[0].RequestProcessingTime)
+	}
+
+	c = NewConnection("", "", WithLocation(afterSpringForward))
+	c.cAPIURLPrefix = ts.URL + "/"
+	nextTrips, err = c.GetNextTripsForStop(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = time.Date(2018, time.March, 11, 6, 30, 0, 0, time.UTC)
+	if !nextTrips.RouteDirections[0].RequestProcessingTime.Equal(expected) {
+		t.Fatalf("Unexpected RequestProcessingTime with EDT offset: %v", nextTrips.RouteDirections[0].RequestProcessingTime)
+	}
+}
+
+func TestTripsWithin(t *testing.T) {
+	rd := RouteDirection{
+		Trips: []Trip{
+			{TripStartTime: "11:13", AdjustedScheduleTime: 16},
+			{TripStartTime: "10:59", AdjustedScheduleTime: 17},
+			{TripStartTime: "11:28", AdjustedScheduleTime: 35},
+		},
+	}
+
+	within := rd.TripsWithin(20)
+	if len(within) != 2 {
+		t.Fatalf("Unexpected number of trips from RouteDirection TripsWithin: %v", len(within))
+	}
+	if within[0].TripStartTime != "11:13" || within[1].TripStartTime != "10:59" {
+		t.Fatal("Unexpected trips from RouteDirection TripsWithin")
+	}
+
+	route := RouteWithTrips{
+		Trips: []Trip{
+			{TripStartTime: "12:46", AdjustedScheduleTime: 14},
+			{TripStartTime: "13:01", AdjustedScheduleTime: 26},
+		},
+	}
+
+	within = route.TripsWithin(20)
+	if len(within) != 1 || within[0].TripStartTime != "12:46" {
+		t.Fatal("Unexpected trips from RouteWithTrips TripsWithin")
+	}
+}
+
+func TestDeadlineTooShort(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnectionWithRateLimit("", "", 0.1, 1)
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.GetNextTripsForStopAllRoutes(ctx, "3020")
+	if !errors.Is(err, ErrDeadlineTooShort) {
+		t.Fatalf("Expected ErrDeadlineTooShort with a slow limiter, got %v", err)
+	}
+
+	c = NewConnection("", "", WithContextDeadlineFloor(time.Second))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err = c.GetNextTripsForStopAllRoutes(ctx, "3020")
+	if !errors.Is(err, ErrDeadlineTooShort) {
+		t.Fatalf("Expected ErrDeadlineTooShort below the deadline floor, got %v", err)
+	}
+	_, err = c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBounds(t *testing.T) {
+	rd := RouteDirection{
+		Trips: []Trip{
+			{Latitude: Latitude{Set: true, Value: 45.431521}, Longitude: Longitude{Set: true, Value: -75.605296}},
+			{Latitude: Latitude{Set: true, Value: 45.426999}, Longitude: Longitude{Set: true, Value: -75.600192}},
+			{Latitude: Latitude{Set: true, Value: 45.455889}, Longitude: Longitude{Set: true, Value: -75.504171}},
+		},
+	}
+
+	minLat, minLon, maxLat, maxLon, ok := rd.Bounds()
+	if !ok {
+		t.Fatal("Expected Bounds for route direction with positions")
+	}
+	if minLat != 45.426999 || minLon != -75.605296 || maxLat != 45.455889 || maxLon != -75.504171 {
+		t.Fatalf("Unexpected route direction Bounds: %v %v %v %v", minLat, minLon, maxLat, maxLon)
+	}
+
+	_, _, _, _, ok = RouteDirection{Trips: []Trip{{TripDestination: "Riverview"}}}.Bounds()
+	if ok {
+		t.Fatal("Unexpected Bounds for route direction without positions")
+	}
+
+	nextTripsAllRoutes := NextTripsForStopAllRoutes{
+		Routes: []RouteWithTrips{
+			{Trips: []Trip{
+				{Latitude: Latitude{Set: true, Value: 45.413769}, Longitude: Longitude{Set: true, Value: -75.710547}},
+				{},
+			}},
+			{Trips: []Trip{
+				{Latitude: Latitude{Set: true, Value: 45.365881}, Longitude: Longitude{Set: true, Value: -75.783288}},
+				{Latitude: Latitude{Set: true, Value: 45.418886}, Longitude: Longitude{Set: true, Value: -75.613623}},
+			}},
+		},
+	}
+
+	minLat, minLon, maxLat, maxLon, ok = nextTripsAllRoutes.Bounds()
+	if !ok {
+		t.Fatal("Expected Bounds for all routes with positions")
+	}
+	if minLat != 45.365881 || minLon != -75.783288 || maxLat != 45.418886 || maxLon != -75.613623 {
+		t.Fatalf("Unexpected all routes Bounds: %v %v %v %v", minLat, minLon, maxLat, maxLon)
+	}
+}
+
+func TestNewConnectionFromEnv(t *testing.T) {
+	t.Setenv(EnvAppID, "")
+	t.Setenv(EnvAPIKey, "")
+	_, err := NewConnectionFromEnv()
+	if err == nil {
+		t.Fatal("Expected error from NewConnectionFromEnv without environment variables")
+	}
+
+	t.Setenv(EnvAppID, "envID")
+	_, err = NewConnectionFromEnv()
+	if err == nil {
+		t.Fatal("Expected error from NewConnectionFromEnv without an API key")
+	}
+
+	t.Setenv(EnvAPIKey, "envKey")
+	c, err := NewConnectionFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != "envID" || c.Key != "envKey" {
+		t.Fatal("Unexpected credentials in Connection from NewConnectionFromEnv")
+	}
+}
+
+func TestNewConnectionFromKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	c, err := NewConnectionFromKeyFile(write("key.json", `{"appID": "fileID", "apiKey": "fileKey"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != "fileID" || c.Key != "fileKey" {
+		t.Fatal("Unexpected credentials in Connection from a JSON key file")
+	}
+
+	c, err = NewConnectionFromKeyFile(write("key.txt", "fileID\r\nfileKey\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != "fileID" || c.Key != "fileKey" {
+		t.Fatal("Unexpected credentials in Connection from a two line key file")
+	}
+
+	_, err = NewConnectionFromKeyFile(filepath.Join(dir, "missing"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected os.ErrNotExist for a missing key file, got %v", err)
+	}
+	for name, content := range map[string]string{
+		"malformed.json": `{"appID": "fileID"`,
+		"nokey.json":     `{"appID": "fileID"}`,
+		"oneline.txt":    "fileID",
+		"empty.txt":      "",
+		"noid.txt":       "\nfileKey",
+	} {
+		_, err = NewConnectionFromKeyFile(write(name, content))
+		if err == nil {
+			t.Fatalf("Expected error from NewConnectionFromKeyFile for %v", name)
+		}
+	}
+}
+
+func TestBusTypeFeatures(t *testing.T) {
+	tests := []struct {
+		busType                               string
+		accessible, doubleDecker, articulated bool
+	}{
+		{"6EB - 60", true, false, true},
+		{"4LB - DD", true, true, false},
+		{"4E - DEH", true, false, false},
+		{" - DD", false, true, false},
+		{"6EAB - 60", true, false, true},
+		{"4B - 40", false, false, false},
+		{"", false, false, false},
+	}
+	for _, test := range tests {
+		trip := Trip{BusType: test.busType}
+		if trip.IsAccessible() != test.accessible {
+			t.Fatalf("Unexpected IsAccessible for BusType %q", test.busType)
+		}
+		if trip.IsDoubleDecker() != test.doubleDecker {
+			t.Fatalf("Unexpected IsDoubleDecker for BusType %q", test.busType)
+		}
+		if trip.IsArticulated() != test.articulated {
+			t.Fatalf("Unexpected IsArticulated for BusType %q", test.busType)
+		}
+	}
+}
+
+// roundTripperFunc adapts a function to an http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWithRoundTripperMiddleware(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	var header string
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Test")
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	addHeader := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			req.Header.Set("X-Test", "middleware")
+			return next.RoundTrip(req)
+		})
+	}
+	c := NewConnection("", "", WithRoundTripperMiddleware(addHeader))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != "middleware" {
+		t.Fatal("Expected header added by middleware in request")
+	}
+	if http.DefaultClient.Transport != nil {
+		t.Fatal("Unexpected change to http.DefaultClient")
+	}
+}
+
+func TestByDestination(t *testing.T) {
+	nextTripsAllRoutes := NextTripsForStopAllRoutes{
+		Routes: []RouteWithTrips{
+			{
+				RouteNo:      "97",
+				Direction:    "Eastbound",
+				RouteHeading: "Airport / Aéroport",
+				Trips: []Trip{
+					{TripDestination: "Airport / Aéroport", TripStartTime: "13:14"},
+					{TripDestination: "Airport / Aéroport", TripStartTime: "13:29"},
+					{TripDestination: "South Keys", TripStartTime: "12:43"},
+				},
+			},
+			{
+				RouteNo:      "98",
+				Direction:    "Northbound",
+				RouteHeading: "Tunney's Pasture",
+				Trips: []Trip{
+					{TripDestination: "LeBreton", TripStartTime: "12:46"},
+					{TripDestination: "LeBreton", TripStartTime: "13:01"},
+				},
+			},
+		},
+	}
+
+	grouped := nextTripsAllRoutes.ByDestination()
+	if len(grouped) != 3 {
+		t.Fatalf("Unexpected number of destinations from ByDestination: %v", len(grouped))
+	}
+	airport := grouped["Airport / Aéroport"]
+	if len(airport) != 2 {
+		t.Fatal("Unexpected number of trips to the Airport from ByDestination")
+	}
+	if airport[0].RouteNo != "97" || airport[0].Direction != "Eastbound" || airport[0].TripStartTime != "13:14" {
+		t.Fatal("Unexpected first trip to the Airport from ByDestination")
+	}
+	if len(grouped["LeBreton"]) != 2 || grouped["LeBreton"][0].RouteNo != "98" {
+		t.Fatal("Unexpected trips to LeBreton from ByDestination")
+	}
+	if len(grouped["South Keys"]) != 1 || grouped["South Keys"][0].RouteHeading != "Airport / Aéroport" {
+		t.Fatal("Unexpected trips to South Keys from ByDestination")
+	}
+}
+
+func TestWithStrictStopResolution(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">9999</StopNo>
+        <StopDescription xmlns="http://tempuri.org/"/>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawNextTripsXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">9999</StopNo>
+        <StopLabel xmlns="http://tempuri.org/"/>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/"/>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/GetNextTripsForStop" {
+			fmt.Fprint(w, rawNextTripsXMLString)
+			return
+		}
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetRouteSummaryForStop(context.TODO(), "9999")
+	if err != nil {
+		t.Fatal("Unexpected error without WithStrictStopResolution", err)
+	}
+	_, err = c.GetNextTripsForStop(context.TODO(), "94", "9999")
+	if err != nil {
+		t.Fatal("Unexpected error without WithStrictStopResolution", err)
+	}
+
+	c = NewConnection("", "", WithStrictStopResolution())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err = c.GetRouteSummaryForStop(context.TODO(), "9999")
+	if err != ErrInvalidStop {
+		t.Fatalf("Expected ErrInvalidStop from GetRouteSummaryForStop, got %v", err)
+	}
+	_, err = c.GetNextTripsForStopAllRoutes(context.TODO(), "9999")
+	if err != ErrInvalidStop {
+		t.Fatalf("Expected ErrInvalidStop from GetNextTripsForStopAllRoutes, got %v", err)
+	}
+	_, err = c.GetNextTripsForStop(context.TODO(), "94", "9999")
+	if err != ErrInvalidStop {
+		t.Fatalf("Expected ErrInvalidStop from GetNextTripsForStop, got %v", err)
+	}
+}
+
+func TestWithErrorPrefix(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <Error xmlns="http://tempuri.org/">10</Error>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Gtfs" && r.URL.Query().Get("table") == "routes" {
+			fmt.Fprint(w, `{"Query":{"table":"routes"},"Gtfs":[]}`)
+			return
+		}
+		if r.URL.Path == "/Gtfs" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "", WithErrorPrefix("octranspo"))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetRouteSummaryForStop(context.TODO(), "000000")
+	if err == nil || !strings.HasPrefix(err.Error(), "octranspo: ") {
+		t.Fatalf("Expected prefixed error from GetRouteSummaryForStop, got %v", err)
+	}
+	if !errors.Is(err, ErrInvalidStop) {
+		t.Fatal("Expected prefixed error to wrap ErrInvalidStop")
+	}
+
+	_, err = c.GetGTFSAgency(context.TODO())
+	if err == nil || !strings.HasPrefix(err.Error(), "octranspo: Non 200") {
+		t.Fatalf("Expected prefixed error from GetGTFSAgency, got %v", err)
+	}
+
+	_, err = c.GetGTFSStops(context.TODO())
+	if err == nil || !strings.HasPrefix(err.Error(), "octranspo: a stop_id") {
+		t.Fatalf("Expected prefixed validation error from GetGTFSStops, got %v", err)
+	}
+
+	_, err = c.RouteLongName(context.TODO(), "1")
+	if err == nil || !strings.HasPrefix(err.Error(), "octranspo: no route found") {
+		t.Fatalf("Expected prefixed error from RouteLongName, got %v", err)
+	}
+
+	err = c.Ping(context.TODO())
+	if err == nil || !strings.HasPrefix(err.Error(), "octranspo: ") || strings.Count(err.Error(), "octranspo") != 1 {
+		t.Fatalf("Expected a single prefix on the error from Ping, got %v", err)
+	}
+	var se *StatusError
+	if !errors.As(err, &se) {
+		t.Fatalf("Expected the error from Ping to wrap a StatusError, got %v", err)
+	}
+}
+
+func TestNextDepartureSummary(t *testing.T) {
+	nextTripsAllRoutes := NextTripsForStopAllRoutes{
+		Routes: []RouteWithTrips{
+			{
+				RouteNo:      "97",
+				Direction:    "Eastbound",
+				RouteHeading: "Airport / Aéroport",
+				Trips:        []Trip{{AdjustedScheduleTime: 8, AdjustmentAge: 0.42}},
+			},
+			{
+				RouteNo:      "97",
+				Direction:    "Westbound",
+				RouteHeading: "Bells Corners",
+				Trips:        []Trip{{AdjustedScheduleTime: 2, AdjustmentAge: 0.44}, {AdjustedScheduleTime: 15, AdjustmentAge: 0.51}},
+			},
+			{
+				RouteNo:      "98",
+				Direction:    "Northbound",
+				RouteHeading: "Tunney's Pasture",
+				Trips:        []Trip{{AdjustedScheduleTime: 26, AdjustmentAge: 0.44}, {AdjustedScheduleTime: 14, AdjustmentAge: -1}},
+			},
+		},
+	}
+
+	summary := nextTripsAllRoutes.NextDepartureSummary()
+	expected := []RouteDeparture{
+		{RouteNo: "97", Direction: "Westbound", RouteHeading: "Bells Corners", MinutesAway: 2, RealTime: true},
+		{RouteNo: "98", Direction: "Northbound", RouteHeading: "Tunney's Pasture", MinutesAway: 14, RealTime: false},
+	}
+	if len(summary) != len(expected) {
+		t.Fatalf("Unexpected number of departures from NextDepartureSummary: %v", len(summary))
+	}
+	for i, d := range summary {
+		if d != expected[i] {
+			t.Fatalf("Unexpected departure from NextDepartureSummary: %v", d)
+		}
+	}
+}
+
+func TestSoapenvPrefix(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soapenv:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Riverview</RouteLabel>
+            <Direction>Westbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Riverview</TripDestination>
+                <TripStartTime>11:13</TripStartTime>
+                <AdjustedScheduleTime>16</AdjustedScheduleTime>
+                <AdjustmentAge>0.34</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soapenv:Body>
+</soapenv:Envelope>`
+
+	rawAllRoutesXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soapenv:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soapenv:Body>
+</soapenv:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/GetNextTripsForStop" {
+			fmt.Fprint(w, rawXMLString)
+			return
+		}
+		fmt.Fprint(w, rawAllRoutesXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTrips, err := c.GetNextTripsForStop(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTrips.StopLabel != "LAURIER STATION" || len(nextTrips.RouteDirections) != 1 {
+		t.Fatal("Unexpected NextTripsForStop from soapenv envelope")
+	}
+
+	nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTripsAllRoutes.StopDescription != "LAURIER STATION" || len(nextTripsAllRoutes.Routes) != 1 {
+		t.Fatal("Unexpected NextTripsForStopAllRoutes from soapenv envelope")
+	}
+}
+
+func TestRouteServesDestination(t *testing.T) {
+	nextTripsAllRoutes := NextTripsForStopAllRoutes{
+		Routes: []RouteWithTrips{
+			{RouteNo: "97", Trips: []Trip{{TripDestination: "Airport / Aéroport"}}},
+			{RouteNo: "97", Trips: []Trip{{TripDestination: "Bayshore"}}},
+			{RouteNo: "98", Trips: []Trip{{TripDestination: "LeBreton"}}},
+		},
+	}
+
+	if !nextTripsAllRoutes.RouteServesDestination("97", "airport") {
+		t.Fatal("Expected route 97 to serve the Airport")
+	}
+	if !nextTripsAllRoutes.RouteServesDestination("97", "BAYSHORE") {
+		t.Fatal("Expected route 97 to serve Bayshore")
+	}
+	if nextTripsAllRoutes.RouteServesDestination("98", "Airport") {
+		t.Fatal("Expected route 98 not to serve the Airport")
+	}
+	if nextTripsAllRoutes.RouteServesDestination("6", "LeBreton") {
+		t.Fatal("Expected a route not at the stop not to serve any destination")
+	}
+}
+
+func TestEmptyResponse(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	for _, body := range []string{"", " \r\n\t "} {
+		rawHandler := func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}
+		ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+
+		c := NewConnection("", "")
+		c.cAPIURLPrefix = ts.URL + "/"
+
+		_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+		if !errors.Is(err, ErrEmptyResponse) {
+			t.Fatalf("Expected ErrEmptyResponse from GetNextTripsForStopAllRoutes, got %v", err)
+		}
+		_, err = c.GetGTFSAgency(context.TODO())
+		if !errors.Is(err, ErrEmptyResponse) {
+			t.Fatalf("Expected ErrEmptyResponse from GetGTFSAgency, got %v", err)
+		}
+		err = c.Ping(context.TODO())
+		if !errors.Is(err, ErrMalformedResponse) {
+			t.Fatalf("Expected ErrMalformedResponse from Ping, got %v", err)
+		}
+		ts.Close()
+	}
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "\n"+rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTripsAllRoutes.StopNo != "3020" {
+		t.Fatal("Unexpected StopNo in returned NextTripsForStopAllRoutes")
+	}
+}
+
+func TestLastTrip(t *testing.T) {
+	rd := RouteDirection{
+		Trips: []Trip{
+			{TripStartTime: "11:13", AdjustedScheduleTime: 16, LastTripOfSchedule: LastTripOfSchedule{Set: true, Value: false}},
+			{TripStartTime: "10:59", AdjustedScheduleTime: 17, LastTripOfSchedule: LastTripOfSchedule{Set: true, Value: false}},
+		},
+	}
+
+	_, ok := rd.LastTrip()
+	if ok {
+		t.Fatal("Expected no LastTrip when no trip is the last of the schedule")
+	}
+
+	rd.Trips[1].LastTripOfSchedule = LastTripOfSchedule{Set: true, Value: true}
+	last, ok := rd.LastTrip()
+	if !ok {
+		t.Fatal("Expected a LastTrip when a trip is the last of the schedule")
+	}
+	if last.TripStartTime != "10:59" {
+		t.Fatal("Unexpected trip returned by LastTrip")
+	}
+	if last.Countdown() != "17 min" {
+		t.Fatalf("Unexpected Countdown of the last trip: %v", last.Countdown())
+	}
+
+	last.AdjustedScheduleTime = 0
+	if last.Countdown() != "Due" {
+		t.Fatalf("Unexpected Countdown of a trip expected now: %v", last.Countdown())
+	}
+}
+
+func TestWithRequestHook(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	var infos []RequestInfo
+	c := NewConnection("someid", "somekey", WithRequestHook(func(info RequestInfo) {
+		infos = append(infos, info)
+	}))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	start := time.Now()
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if len(infos) != 1 {
+		t.Fatalf("Unexpected number of calls to the request hook: %v", len(infos))
+	}
+	info := infos[0]
+	if info.Method != "POST" || info.URL != ts.URL+"/GetNextTripsForStopAllRoutes" || info.StatusCode != 200 || info.Err != nil {
+		t.Fatalf("Unexpected RequestInfo passed to the request hook: %+v", info)
+	}
+	if info.Latency < 10*time.Millisecond || info.DecodeDuration < 0 {
+		t.Fatalf("Unexpected durations passed to the request hook: %v %v", info.Latency, info.DecodeDuration)
+	}
+	if info.Latency+info.DecodeDuration > elapsed {
+		t.Fatalf("Expected the durations to fit within the call: %v %v %v", info.Latency, info.DecodeDuration, elapsed)
+	}
+
+	_, err = c.GetGTFSAgency(context.TODO())
+	if err == nil {
+		t.Fatal("Expected error decoding a SOAP response as GTFS")
+	}
+	if len(infos) != 2 || infos[1].Method != "GET" || strings.Contains(infos[1].URL, "somekey") {
+		t.Fatalf("Unexpected RequestInfo passed to the request hook for GTFS: %+v", infos)
+	}
+}
+
+func TestStopServicesRoute(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">7659</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">BANK / FIFTH</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>6</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Northbound</Direction>
+            <RouteHeading>Rockcliffe</RouteHeading>
+          </Route>
+          <Route>
+            <RouteNo>7</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>St-Laurent</RouteHeading>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	requests := 0
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	serves, err := c.StopServicesRoute(context.TODO(), "7659", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !serves {
+		t.Fatal("Expected route 7 to serve stop 7659")
+	}
+
+	serves, err = c.StopServicesRoute(context.TODO(), "7659", "95")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serves {
+		t.Fatal("Expected route 95 not to serve stop 7659")
+	}
+
+	if requests != 1 {
+		t.Fatalf("Expected the route summary to be reused, got %v requests", requests)
+	}
+
+	summary, err := c.cachedRouteSummary(context.TODO(), "7659")
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary.Routes[1].RouteNo = "95"
+	serves, err = c.StopServicesRoute(context.TODO(), "7659", "95")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serves {
+		t.Fatal("Expected changes to a returned route summary not to reach the cache")
+	}
+
+	_, err = c.StopServicesRoute(context.TODO(), "7659", "7", WithCredentials("other", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("Expected a separate route summary for other credentials, got %v requests", requests)
+	}
+}
+
+func TestArrivalTime(t *testing.T) {
+	rd := RouteDirection{
+		RequestProcessingTime: time.Date(2018, time.August, 31, 11, 40, 42, 0, time.FixedZone("EDT", -4*60*60)),
+		Trips:                 []Trip{{AdjustedScheduleTime: 16, AdjustmentAge: 0.34}},
+	}
+
+	trip := rd.Trips[0]
+	arrival, live := rd.ArrivalTime(trip)
+	if !live {
+		t.Fatal("Expected a live ArrivalTime for a trip with GPS data")
+	}
+	if !arrival.Equal(rd.RequestProcessingTime.Add(16 * time.Minute)) {
+		t.Fatalf("Unexpected ArrivalTime: %v", arrival)
+	}
+
+	trip.AdjustmentAge = -1
+	scheduled, live := rd.ArrivalTime(trip)
+	if live {
+		t.Fatal("Expected a scheduled only trip's ArrivalTime not to be live")
+	}
+	if !scheduled.Equal(arrival) {
+		t.Fatalf("Unexpected ArrivalTime for a scheduled only trip: %v", scheduled)
+	}
+}
+
+func TestArrivalTimeRFC3339(t *testing.T) {
+	rd := RouteDirection{
+		RequestProcessingTime: time.Date(2018, time.August, 31, 11, 40, 42, 0, time.FixedZone("EDT", -4*60*60)),
+	}
+
+	formatted := rd.ArrivalTimeRFC3339(Trip{AdjustedScheduleTime: 16, AdjustmentAge: 0.34})
+	if formatted != "2018-08-31T11:56:42-04:00" {
+		t.Fatalf("Unexpected ArrivalTimeRFC3339: %v", formatted)
+	}
+}
+
+func TestContextErrors(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	done := make(chan struct{})
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Gtfs" {
+			fmt.Fprint(w, `{"Query":{"table":"agency"},"Gtfs":[`)
+		} else {
+			fmt.Fprint(w, rawXMLString[:200])
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+	defer close(done)
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GetNextTripsForStopAllRoutes(ctx, "3020")
+	if !errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.Canceled for a canceled context, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = c.GetNextTripsForStopAllRoutes(ctx, "3020")
+	if !errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.Canceled when canceled while decoding, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.GetGTFSAgency(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.DeadlineExceeded when the deadline passes while decoding, got %v", err)
+	}
+}
+
+func TestNextTripsRequest(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.413769</Latitude>
+                <Longitude>-75.710547</Longitude>
+                <GPSSpeed>25.7</GPSSpeed>
+              </Trip>
+            </Trips>
+          </Route>
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Westbound</Direction>
+            <RouteHeading>Bells Corners</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Bayshore</TripDestination>
+                <TripStartTime>12:57</TripStartTime>
+                <AdjustedScheduleTime>2</AdjustedScheduleTime>
+                <AdjustmentAge>0.44</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>4E - DEH</BusType>
+                <Latitude>45.418886</Latitude>
+                <Longitude>-75.678187</Longitude>
+                <GPSSpeed>22.4</GPSSpeed>
+              </Trip>
+              <Trip>
+                <TripDestination>Bells Corners</TripDestination>
+                <TripStartTime>13:12</TripStartTime>
+                <AdjustedScheduleTime>15</AdjustedScheduleTime>
+                <AdjustmentAge>0.51</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.387714</Latitude>
+                <Longitude>-75.673109</Longitude>
+                <GPSSpeed>71.9</GPSSpeed>
+              </Trip>
+              <Trip>
+                <TripDestination>Tunney's Pasture</TripDestination>
+                <TripStartTime>13:32</TripStartTime>
+                <AdjustedScheduleTime>36</AdjustedScheduleTime>
+                <AdjustmentAge>-1</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </Route>
+          <Route>
+            <RouteNo>98</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Northbound</Direction>
+            <RouteHeading>Tunney's Pasture</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>LeBreton</TripDestination>
+                <TripStartTime>12:46</TripStartTime>
+                <AdjustedScheduleTime>14</AdjustedScheduleTime>
+                <AdjustmentAge>0.37</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.410505</Latitude>
+                <Longitude>-75.664115</Longitude>
+                <GPSSpeed>51.1</GPSSpeed>
+              </Trip>
+            </Trips>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTrips, err := c.NextTrips("3020").Route("97").Direction("Westbound").Within(30).Do(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manual, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []Trip
+	for _, rt := range manual.Routes {
+		if rt.RouteNo == "97" && rt.Direction == "Westbound" {
+			expected = append(expected, rt.TripsWithin(30)...)
+		}
+	}
+
+	if nextTrips.StopNo != "3020" {
+		t.Fatal("Unexpected StopNo in returned NextTripsForStopAllRoutes")
+	}
+	if len(nextTrips.Routes) != 1 || nextTrips.Routes[0].RouteNo != "97" || nextTrips.Routes[0].Direction != "Westbound" {
+		t.Fatalf("Unexpected Routes in returned NextTripsForStopAllRoutes: %v", nextTrips.Routes)
+	}
+	if len(expected) == 0 || len(nextTrips.Routes[0].Trips) != len(expected) {
+		t.Fatal("Unexpected number of Trips in returned NextTripsForStopAllRoutes")
+	}
+	for i, trip := range nextTrips.Routes[0].Trips {
+		if trip != expected[i] {
+			t.Fatalf("Unexpected Trip in returned NextTripsForStopAllRoutes: %v", trip)
+		}
+	}
+
+	nextTrips, err = c.NextTrips("3020").Direction("W").Do(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nextTrips.Routes) != 1 || nextTrips.Routes[0].Direction != "Westbound" {
+		t.Fatal("Unexpected Routes when filtering by a short Direction")
+	}
+
+	nextTrips, err = c.NextTrips("3020").Do(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nextTrips.Routes) != len(manual.Routes) {
+		t.Fatal("Expected all Routes without any filters")
+	}
+}
+
+func TestTripPublic(t *testing.T) {
+	trip := Trip{
+		TripDestination:      "Airport / Aéroport",
+		TripStartTime:        "11:47",
+		AdjustedScheduleTime: 8,
+		AdjustmentAge:        0.5,
+		LastTripOfSchedule:   LastTripOfSchedule{Set: true, Value: false},
+		BusType:              "6EB - 60",
+		Latitude:             Latitude{Set: true, Value: 45.418886},
+		Longitude:            Longitude{Set: true, Value: -75.688347},
+		GPSSpeed:             GPSSpeed{Set: true, Value: 12.5},
+	}
+	encoded, err := json.Marshal(trip.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"tripDestination":"Airport / Aéroport","tripStartTime":"11:47","adjustedScheduleTime":8,` +
+		`"adjustmentAge":0.5,"lastTripOfSchedule":false,"busType":"6EB - 60",` +
+		`"latitude":45.418886,"longitude":-75.688347,"gpsSpeed":12.5}`
+	if string(encoded) != expected {
+		t.Fatalf("Unexpected JSON for a PublicTrip with set fields: %s", encoded)
+	}
+
+	trip.LastTripOfSchedule = LastTripOfSchedule{}
+	trip.Latitude = Latitude{}
+	trip.Longitude = Longitude{}
+	trip.GPSSpeed = GPSSpeed{}
+	encoded, err = json.Marshal(trip.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"tripDestination":"Airport / Aéroport","tripStartTime":"11:47","adjustedScheduleTime":8,` +
+		`"adjustmentAge":0.5,"lastTripOfSchedule":null,"busType":"6EB - 60",` +
+		`"latitude":null,"longitude":null,"gpsSpeed":null}`
+	if string(encoded) != expected {
+		t.Fatalf("Unexpected JSON for a PublicTrip with unset fields: %s", encoded)
+	}
+}
+
+func TestSetBurst(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnectionWithRateLimit("", "", 10, 1)
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = c.GetNextTripsForStopAllRoutes(ctx, "3020")
+	cancel()
+	if !errors.Is(err, ErrDeadlineTooShort) {
+		t.Fatalf("Expected ErrDeadlineTooShort once the burst is used, got %v", err)
+	}
+
+	c.SetBurst(5)
+	if c.Limiter.Burst() != 5 || c.Limiter.Limit() != 10 {
+		t.Fatal("Expected SetBurst to change the burst and keep the rate")
+	}
+	time.Sleep(600 * time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.GetNextTripsForStopAllRoutes(ctx, "3020")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Expected requests within the larger burst to succeed, got %v", err)
+		}
+	}
+
+	_, err = c.GetNextTripsForStopAllRoutes(ctx, "3020")
+	if !errors.Is(err, ErrDeadlineTooShort) {
+		t.Fatalf("Expected ErrDeadlineTooShort once the larger burst is used, got %v", err)
+	}
+}
+
+func TestEstimatedETAFromGPS(t *testing.T) {
+	trip := Trip{
+		Latitude:  Latitude{Set: true, Value: 45.431521},
+		Longitude: Longitude{Set: true, Value: -75.605296},
+		GPSSpeed:  GPSSpeed{Set: true, Value: 63},
+	}
+
+	// About 5.2 km east of the bus, which is moving at 63 km/h.
+	eta, ok := trip.EstimatedETAFromGPS(45.431521, -75.538796)
+	if !ok {
+		t.Fatal("Expected an EstimatedETAFromGPS for a moving bus")
+	}
+	if eta < 4*time.Minute+50*time.Second || eta > 5*time.Minute+10*time.Second {
+		t.Fatalf("Unexpected EstimatedETAFromGPS: %v", eta)
+	}
+
+	trip.GPSSpeed = GPSSpeed{Set: true, Value: 0}
+	_, ok = trip.EstimatedETAFromGPS(45.431521, -75.538796)
+	if ok {
+		t.Fatal("Expected no EstimatedETAFromGPS for a stopped bus")
+	}
+
+	trip.GPSSpeed = GPSSpeed{Set: true, Value: 63}
+	trip.Latitude = Latitude{}
+	_, ok = trip.EstimatedETAFromGPS(45.431521, -75.538796)
+	if ok {
+		t.Fatal("Expected no EstimatedETAFromGPS for a bus without a position")
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	var infos []RequestInfo
+	c := NewConnectionWithRateLimit("", "", 10, 1, WithCaptureTiming(), WithRequestHook(func(info RequestInfo) {
+		infos = append(infos, info)
+	}))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	first, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 2 {
+		t.Fatalf("Unexpected number of calls to the request hook: %v", len(infos))
+	}
+	if infos[1].LimiterWait < 50*time.Millisecond || infos[1].LimiterWait < infos[0].LimiterWait {
+		t.Fatalf("Expected the second request to wait for the limiter, got %v", infos[1].LimiterWait)
+	}
+	if first.Timing == nil || second.Timing == nil {
+		t.Fatal("Expected Timing with WithCaptureTiming")
+	}
+	if second.Timing.LimiterWait != infos[1].LimiterWait || second.Timing.DecodeDuration != infos[1].DecodeDuration {
+		t.Fatal("Expected Timing to match the RequestInfo passed to the request hook")
+	}
+
+	c = NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTrips, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTrips.Timing != nil {
+		t.Fatal("Expected no Timing without WithCaptureTiming")
+	}
+}
+
+func TestWithoutRateLimit(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	var form url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.Form
+		if r.URL.Path == "/Gtfs" {
+			fmt.Fprint(w, `{"Query":{"table":"agency","direction":"ASC","format":"json"},
+			                "Gtfs":[{"id":"1","agency_name":"OC Transpo"}]}`)
+			return
+		}
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnectionWithRateLimit("", "", 0.0001, 1)
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = c.GetNextTripsForStopAllRoutes(ctx, "3020", WithoutRateLimit())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(form) != 3 {
+		t.Fatalf("Unexpected parameters sent with WithoutRateLimit: %v", form)
+	}
+	_, err = c.GetGTFSAgency(ctx, WithoutRateLimit())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.GetNextTripsForStopAllRoutes(ctx, "3020")
+	if err == nil {
+		t.Fatal("Expected the limiter to still apply without WithoutRateLimit")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.GetNextTripsForStopAllRoutes(ctx, "3020", Param("gooctranspoapi.noRateLimit", "true"))
+	if err == nil {
+		t.Fatal("Expected the limiter to still apply with a parameter named like WithoutRateLimit's")
+	}
+}
+
+func TestTripsOnRoute(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Riverview</RouteLabel>
+            <Direction>Westbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Riverview</TripDestination>
+                <TripStartTime>11:13</TripStartTime>
+                <AdjustedScheduleTime>16</AdjustedScheduleTime>
+                <AdjustmentAge>0.34</AdjustmentAge>
+                <LastTripOfSchedule>false</LastTripOfSchedule>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.431521</Latitude>
+                <Longitude>-75.605296</Longitude>
+                <GPSSpeed>63.0</GPSSpeed>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Millennium</RouteLabel>
+            <Direction>Eastbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Millennium</TripDestination>
+                <TripStartTime>11:00</TripStartTime>
+                <AdjustedScheduleTime>12</AdjustedScheduleTime>
+                <AdjustmentAge>0.44</AdjustmentAge>
+                <LastTripOfSchedule>false</LastTripOfSchedule>
+                <BusType>4EB - DD</BusType>
+                <Latitude>45.404710</Latitude>
+                <Longitude>-75.732058</Longitude>
+                <GPSSpeed>15.9</GPSSpeed>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawOtherStopXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3021</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">CAMPUS</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Riverview</RouteLabel>
+            <Direction>Westbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Riverview</TripDestination>
+                <TripStartTime>11:13</TripStartTime>
+                <AdjustedScheduleTime>16</AdjustedScheduleTime>
+                <AdjustmentAge>0.34</AdjustmentAge>
+                <LastTripOfSchedule>false</LastTripOfSchedule>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.431521</Latitude>
+                <Longitude>-75.605296</Longitude>
+                <GPSSpeed>63.0</GPSSpeed>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Millennium</RouteLabel>
+            <Direction>Eastbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Millennium</TripDestination>
+                <TripStartTime>11:45</TripStartTime>
+                <AdjustedScheduleTime>27</AdjustedScheduleTime>
+                <AdjustmentAge>0.44</AdjustmentAge>
+                <LastTripOfSchedule>false</LastTripOfSchedule>
+                <BusType>4EB - DD</BusType>
+                <Latitude>45.404710</Latitude>
+                <Longitude>-75.732058</Longitude>
+                <GPSSpeed>15.9</GPSSpeed>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("stopNo") == "3021" {
+			fmt.Fprint(w, rawOtherStopXMLString)
+			return
+		}
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	trips, err := c.TripsOnRoute(context.TODO(), "94", []string{"3020", "3021"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trips) != 3 {
+		t.Fatalf("Unexpected number of trips from TripsOnRoute: %v", len(trips))
+	}
+	if trips[0].RouteNo != "94" || trips[0].Direction != "Westbound" || trips[0].RouteHeading != "Riverview" || trips[0].TripStartTime != "11:13" {
+		t.Fatalf("Unexpected first trip from TripsOnRoute: %+v", trips[0])
+	}
+	if trips[2].Direction != "Eastbound" || trips[2].TripStartTime != "11:45" {
+		t.Fatalf("Unexpected trip only seen at the second stop from TripsOnRoute: %+v", trips[2])
+	}
+}
+
+func TestDecodeUnexpectedEnvelopes(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.413769</Latitude>
+                <Longitude>-75.710547</Longitude>
+                <GPSSpeed>25.7</GPSSpeed>
+              </Trip>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:29</TripStartTime>
+                <AdjustedScheduleTime>22</AdjustedScheduleTime>
+                <AdjustmentAge>-1</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>4LB - DD</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	withoutProlog := strings.TrimPrefix(rawXMLString, `<?xml version="1.0" encoding="utf-8"?>`)
+	bodies := map[string]string{
+		"BOM prefixed": "\xEF\xBB\xBF" + rawXMLString,
+		"wrapped":      `<?xml version="1.0" encoding="utf-8"?><GatewayResponse status="ok">` + withoutProlog + `</GatewayResponse>`,
+	}
+	for name, body := range bodies {
+		rawHandler := func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}
+		ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+
+		c := NewConnection("", "")
+		c.cAPIURLPrefix = ts.URL + "/"
+
+		nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+		ts.Close()
+		if err != nil {
+			t.Fatalf("Unexpected error decoding a %v response: %v", name, err)
+		}
+		if nextTripsAllRoutes.StopNo != "3020" || len(nextTripsAllRoutes.Routes) != 1 {
+			t.Fatalf("Unexpected NextTripsForStopAllRoutes from a %v response", name)
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><html><body>Gateway error</body></html>`)
+	}))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err == nil {
+		t.Fatal("Expected error from a response without an Envelope")
+	}
+}
+
+func TestWithRequestCoalescing(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.413769</Latitude>
+                <Longitude>-75.710547</Longitude>
+                <GPSSpeed>25.7</GPSSpeed>
+              </Trip>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:29</TripStartTime>
+                <AdjustedScheduleTime>22</AdjustedScheduleTime>
+                <AdjustmentAge>-1</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>4LB - DD</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	var mu sync.Mutex
+	requests := 0
+	release := make(chan struct{})
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		<-release
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "", WithRequestCoalescing())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	const n = 10
+	var wg sync.WaitGroup
+	results := make([]*NextTripsForStopAllRoutes, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+		}(i)
+	}
+	// Give the calls time to join the first request before it completes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if requests != 1 {
+		t.Fatalf("Expected one request for concurrent identical calls, got %v", requests)
+	}
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if results[i].StopNo != "3020" || len(results[i].Routes) != 1 {
+			t.Fatal("Unexpected NextTripsForStopAllRoutes from a coalesced call")
+		}
+	}
+	if results[0] == results[1] || &results[0].Routes[0] == &results[1].Routes[0] {
+		t.Fatal("Expected each coalesced call to decode its own result")
+	}
+
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("Expected a new request once the first had completed, got %v", requests)
+	}
+
+	block := make(chan struct{})
+	blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		<-block
+		fmt.Fprint(w, rawXMLString)
+	}))
+	defer blocking.Close()
+	c.cAPIURLPrefix = blocking.URL + "/"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceledErr := make(chan error, 1)
+	go func() {
+		_, err := c.GetNextTripsForStopAllRoutes(ctx, "3020")
+		canceledErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	waiterErr := make(chan error, 1)
+	go func() {
+		_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+		waiterErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-canceledErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled for the canceled caller, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the canceled caller to return without waiting for the shared request")
+	}
+	close(block)
+	err = <-waiterErr
+	if err != nil {
+		t.Fatalf("Expected the shared request to survive the first caller's cancellation, got %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("Expected one request for the canceled and waiting calls, got %v", requests-2)
+	}
+
+	stalls := 0
+	stalledCanceled := make(chan struct{}, 1)
+	stalling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Reading the body lets the server notice the client canceling the request.
+		r.ParseForm()
+		mu.Lock()
+		stalls++
+		first := stalls == 1
+		mu.Unlock()
+		if first {
+			<-r.Context().Done()
+			stalledCanceled <- struct{}{}
+			return
+		}
+		fmt.Fprint(w, rawXMLString)
+	}))
+	defer stalling.Close()
+	c.cAPIURLPrefix = stalling.URL + "/"
+
+	var waiters sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		waiters.Add(1)
+		go func() {
+			defer waiters.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err := c.GetNextTripsForStopAllRoutes(ctx, "3020")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Expected context.DeadlineExceeded from a stalled request, got %v", err)
+			}
+		}()
+	}
+	waiters.Wait()
+	select {
+	case <-stalledCanceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the shared request to be canceled once every caller gave up")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = c.GetNextTripsForStopAllRoutes(ctx, "3020")
+	if err != nil {
+		t.Fatalf("Expected a new request after every caller gave up on the last, got %v", err)
+	}
+	if stalls != 2 {
+		t.Fatalf("Unexpected number of requests to the stalled server: %v", stalls)
+	}
+}
+
+func TestStopName(t *testing.T) {
+	cases := []struct {
+		namer    StopNamer
+		expected string
+	}{
+		{&RouteSummaryForStop{StopNo: "7659", StopDescription: "BANK / FIFTH"}, "BANK / FIFTH"},
+		{&NextTripsForStop{StopNo: "3020", StopLabel: "LAURIER STATION"}, "LAURIER STATION"},
+		{&NextTripsForStopAllRoutes{StopNo: "3020", StopDescription: "LAURIER STATION"}, "LAURIER STATION"},
+	}
+	for _, c := range cases {
+		if c.namer.StopName() != c.expected {
+			t.Fatalf("Unexpected StopName of %T: %v", c.namer, c.namer.StopName())
+		}
+	}
+}
+
+func TestNextTripsForStopAllRoutesEnvelopeNames(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.413769</Latitude>
+                <Longitude>-75.710547</Longitude>
+                <GPSSpeed>25.7</GPSSpeed>
+              </Trip>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:29</TripStartTime>
+                <AdjustedScheduleTime>22</AdjustedScheduleTime>
+                <AdjustmentAge>-1</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>4LB - DD</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	renamed := strings.NewReplacer(
+		"GetRouteSummaryForStopResponse", "GetNextTripsForStopAllRoutesResponse",
+		"GetRouteSummaryForStopResult", "GetNextTripsForStopAllRoutesResult",
+	).Replace(rawXMLString)
+
+	for _, body := range []string{rawXMLString, renamed} {
+		rawHandler := func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}
+		ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+
+		c := NewConnection("", "")
+		c.cAPIURLPrefix = ts.URL + "/"
+
+		nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nextTripsAllRoutes.StopNo != "3020" || nextTripsAllRoutes.StopDescription != "LAURIER STATION" {
+			t.Fatal("Unexpected stop in returned NextTripsForStopAllRoutes")
+		}
+		if len(nextTripsAllRoutes.Routes) != 1 || len(nextTripsAllRoutes.Routes[0].Trips) != 2 {
+			t.Fatal("Unexpected Routes in returned NextTripsForStopAllRoutes")
+		}
+	}
+}
+
+func TestScheduleURLs(t *testing.T) {
+	c := NewConnection("someid", "somekey")
+
+	stopURL := c.StopScheduleURL("3020")
+	if stopURL != "https://www.octranspo.com/en/plan-your-trip/schedules-maps/?sched-type=stop&stop=3020" {
+		t.Fatalf("Unexpected StopScheduleURL: %v", stopURL)
+	}
+	routeURL := c.RouteScheduleURL("R2")
+	if routeURL != "https://www.octranspo.com/en/plan-your-trip/schedules-maps/?route=R2&sched-type=route" {
+		t.Fatalf("Unexpected RouteScheduleURL: %v", routeURL)
+	}
+	if strings.Contains(c.StopScheduleURL("30 20&x"), " ") {
+		t.Fatal("Expected StopScheduleURL to escape the stop number")
+	}
+}
+
+func TestWithCredentials(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	var received []url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		received = append(received, r.Form)
+		if r.URL.Path == "/Gtfs" {
+			fmt.Fprint(w, `{"Query":{"table":"agency"},"Gtfs":[]}`)
+			return
+		}
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("defaultid", "defaultkey")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020", WithCredentials("tenantid", "tenantkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetGTFSAgency(context.TODO(), WithCredentials("tenantid", "tenantkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, form := range received[:2] {
+		if form.Get("appID") != "tenantid" || form.Get("apiKey") != "tenantkey" || len(form["apiKey"]) != 1 {
+			t.Fatalf("Unexpected credentials in request %v: %v", i, form)
+		}
+	}
+	if received[2].Get("appID") != "defaultid" || received[2].Get("apiKey") != "defaultkey" {
+		t.Fatal("Expected the connection's credentials without WithCredentials")
+	}
+}
+
+func TestHasImminentArrival(t *testing.T) {
+	nextTripsAllRoutes := NextTripsForStopAllRoutes{
+		Routes: []RouteWithTrips{
+			{RouteNo: "97", Trips: []Trip{{AdjustedScheduleTime: 8}}},
+			{RouteNo: "97", Trips: []Trip{{AdjustedScheduleTime: 2}}},
+		},
+	}
+
+	if !nextTripsAllRoutes.HasImminentArrival(2) {
+		t.Fatal("Expected an imminent arrival within 2 minutes")
+	}
+	if nextTripsAllRoutes.HasImminentArrival(1) {
+		t.Fatal("Expected no imminent arrival within 1 minute")
+	}
+	if (&NextTripsForStopAllRoutes{}).HasImminentArrival(2) {
+		t.Fatal("Expected no imminent arrival without any routes")
+	}
+}
+
+func TestRouteCards(t *testing.T) {
+	nextTripsAllRoutes := NextTripsForStopAllRoutes{
+		Routes: []RouteWithTrips{
+			{RouteNo: "97", Direction: "Eastbound", RouteHeading: "Airport / Aéroport"},
+			{RouteNo: "97", Direction: "Westbound", RouteHeading: "Bells Corners"},
+			{RouteNo: "98", Direction: "Northbound", RouteHeading: "Tunney's Pasture"},
+		},
+	}
+
+	cards := nextTripsAllRoutes.RouteCards()
+	if len(cards) != 2 {
+		t.Fatalf("Unexpected number of cards in returned RouteCards: %v", len(cards))
+	}
+	if cards[0].RouteNo != "97" || len(cards[0].Directions) != 2 {
+		t.Fatal("Unexpected first card in returned RouteCards")
+	}
+	if cards[0].Directions[0].RouteHeading != "Airport / Aéroport" || cards[0].Directions[1].RouteHeading != "Bells Corners" {
+		t.Fatal("Unexpected directions in returned RouteCards")
+	}
+	if cards[1].RouteNo != "98" || len(cards[1].Directions) != 1 {
+		t.Fatal("Unexpected second card in returned RouteCards")
+	}
+	if (&NextTripsForStopAllRoutes{}).RouteCards() != nil {
+		t.Fatal("Expected no cards without any routes")
+	}
+}
+
+func TestClose(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+          </Route>
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Westbound</Direction>
+            <RouteHeading>Bells Corners</RouteHeading>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+	c.routeSummaries.ttl = 10 * time.Millisecond
+
+	serves, err := c.StopServicesRoute(context.TODO(), "3020", "97")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !serves {
+		t.Fatal("Expected route 97 to serve stop 3020")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.routeSummaries.mu.Lock()
+		remaining := len(c.routeSummaries.entries)
+		c.routeSummaries.mu.Unlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the janitor to evict the expired route summary")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.routeSummaries.stopped:
+	default:
+		t.Fatal("Expected Close to stop the janitor")
+	}
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.StopServicesRoute(context.TODO(), "3020", "97")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.routeSummaries.mu.Lock()
+	started := c.routeSummaries.started
+	c.routeSummaries.mu.Unlock()
+	if !started || len(c.routeSummaries.entries) != 1 {
+		t.Fatal("Expected the connection to still cache route summaries after Close")
+	}
+
+	c = NewConnection("", "")
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Close() != nil {
+		t.Fatal("Expected Close to be idempotent without the janitor having started")
+	}
+}
+
+func TestWithPartialOnTimeout(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Riverview</RouteLabel>
+            <Direction>Westbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Riverview</TripDestination>
+                <TripStartTime>11:13</TripStartTime>
+                <AdjustedScheduleTime>16</AdjustedScheduleTime>
+                <AdjustmentAge>0.34</AdjustmentAge>
+                <LastTripOfSchedule>false</LastTripOfSchedule>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.431521</Latitude>
+                <Longitude>-75.605296</Longitude>
+                <GPSSpeed>63.0</GPSSpeed>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	done := make(chan struct{})
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("stopNo") == "3021" {
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+	defer close(done)
+
+	c := NewConnection("", "", WithPartialOnTimeout())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	trips, err := c.TripsOnRoute(ctx, "94", []string{"3020", "3021"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if len(trips) == 0 {
+		t.Fatal("Expected the trips from the stop which responded")
+	}
+
+	c = NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	trips, err = c.TripsOnRoute(ctx, "94", []string{"3020", "3021"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if trips != nil {
+		t.Fatal("Expected no trips without WithPartialOnTimeout")
+	}
+}
+
+func TestHasAnyPosition(t *testing.T) {
+	route := RouteWithTrips{
+		RouteNo: "97",
+		Trips: []Trip{
+			{TripDestination: "Airport / Aéroport", AdjustedScheduleTime: 8,
+				Latitude: Latitude{Set: true, Value: 45.413769}, Longitude: Longitude{Set: true, Value: -75.710547}},
+			{TripDestination: "Airport / Aéroport", AdjustedScheduleTime: 22},
+		},
+	}
+	if !route.HasAnyPosition() {
+		t.Fatal("Expected a position on a route with a positioned trip")
+	}
+
+	rail := RouteWithTrips{
+		RouteNo: "1",
+		Trips: []Trip{
+			{TripDestination: "Blair", AdjustedScheduleTime: 3},
+			{TripDestination: "Blair", AdjustedScheduleTime: 8, Latitude: Latitude{Set: true, Value: 45.42}},
+		},
+	}
+	if rail.HasAnyPosition() {
+		t.Fatal("Expected no position on a route whose trips all lack positions")
+	}
+	if (RouteWithTrips{}).HasAnyPosition() {
+		t.Fatal("Expected no position on a route without trips")
+	}
+}
+
+func TestSameTrip(t *testing.T) {
+	before := Trip{
+		TripDestination:      "Airport / Aéroport",
+		TripStartTime:        "13:14",
+		AdjustedScheduleTime: 8,
+		BusType:              "6EB - 60",
+		Latitude:             Latitude{Set: true, Value: 45.413769},
+	}
+
+	after := before
+	after.AdjustedScheduleTime = 5
+	after.Latitude.Value += 0.01
+	if !SameTrip(before, after) {
+		t.Fatal("Expected the same trip across polls")
+	}
+	after.BusType = ""
+	if !SameTrip(before, after) {
+		t.Fatal("Expected the same trip without a BusType")
+	}
+	after.BusType = "4LB - DD"
+	if SameTrip(before, after) {
+		t.Fatal("Expected a different trip with a different BusType")
+	}
+	later := before
+	later.TripStartTime = "13:29"
+	if SameTrip(before, later) {
+		t.Fatal("Expected a different trip with a different start time")
+	}
+}
+
+func TestWithLenientParsing(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.41.3769</Latitude>
+                <Longitude>-75.710547</Longitude>
+                <GPSSpeed>25.7</GPSSpeed>
+              </Trip>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:29</TripStartTime>
+                <AdjustedScheduleTime>22</AdjustedScheduleTime>
+                <AdjustmentAge>-1</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>4LB - DD</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err == nil {
+		t.Fatal("Expected error for a malformed Latitude without WithLenientParsing")
+	}
+
+	c = NewConnection("", "", WithLenientParsing())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nextTripsAllRoutes.Warnings) != 1 {
+		t.Fatalf("Unexpected number of Warnings: %v", len(nextTripsAllRoutes.Warnings))
+	}
+	w := nextTripsAllRoutes.Warnings[0]
+	if w.RouteNo != "97" || w.TripStartTime != "13:14" || w.Field != "Latitude" || w.Value != "45.41.3769" || w.Err == nil {
+		t.Fatalf("Unexpected warning: %v", w)
+	}
+	trip := nextTripsAllRoutes.Routes[0].Trips[0]
+	if trip.Latitude.Set || !trip.Longitude.Set || trip.AdjustedScheduleTime != 8 || trip.BusType != "6EB - 60" {
+		t.Fatal("Unexpected trip with a malformed Latitude")
+	}
+	if len(nextTripsAllRoutes.Routes) != 1 || len(nextTripsAllRoutes.Routes[0].Trips) != 2 {
+		t.Fatal("Expected the rest of the data to be intact")
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Riverview</RouteLabel>
+            <Direction>Westbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Riverview</TripDestination>
+                <TripStartTime>11:13</TripStartTime>
+                <AdjustedScheduleTime>16</AdjustedScheduleTime>
+                <AdjustmentAge>0.34</AdjustmentAge>
+                <LastTripOfSchedule>false</LastTripOfSchedule>
+                <BusType>6EB - 60</BusType>
+                <Latitude>45.431521</Latitude>
+                <Longitude>-75.605296</Longitude>
+                <GPSSpeed>63.0</GPSSpeed>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	var mu sync.Mutex
+	var methods []string
+	opened := 0
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(rawHandler))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			opened++
+			mu.Unlock()
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.HTTPClient = ts.Client()
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	err := c.Warmup(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if opened != 1 || len(methods) != 1 || methods[0] != "HEAD" {
+		mu.Unlock()
+		t.Fatal("Expected Warmup to establish a connection")
+	}
+	mu.Unlock()
+
+	_, err = c.GetNextTripsForStop(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if opened != 1 {
+		t.Fatalf("Expected the request to reuse the warmed up connection, %v were opened", opened)
+	}
+}
+
+func TestServiceMaintenance(t *testing.T) {
+	rawMaintenanceXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/">The system is down for scheduled maintenance. Please try again later.</Error>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawOutageXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3021</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">CAMPUS</StopDescription>
+        <Error xmlns="http://tempuri.org/">Data feed offline</Error>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("stopNo") == "3021" {
+			fmt.Fprint(w, rawOutageXMLString)
+			return
+		}
+		fmt.Fprint(w, rawMaintenanceXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if !errors.Is(err, ErrServiceMaintenance) {
+		t.Fatalf("Expected ErrServiceMaintenance, got %v", err)
+	}
+
+	nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3021")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTripsAllRoutes.Error != "Data feed offline" {
+		t.Fatal("Unexpected Error in returned NextTripsForStopAllRoutes")
+	}
+
+	c = NewConnection("", "", WithMaintenanceMessages("feed offline"))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err = c.GetNextTripsForStopAllRoutes(context.TODO(), "3021")
+	if !errors.Is(err, ErrServiceMaintenance) {
+		t.Fatalf("Expected ErrServiceMaintenance for an added message, got %v", err)
+	}
+}
+
+func TestNormalizeRouteNo(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Riverview</RouteLabel>
+            <Direction>Westbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Riverview</TripDestination>
+                <TripStartTime>11:13</TripStartTime>
+                <AdjustedScheduleTime>16</AdjustedScheduleTime>
+                <AdjustmentAge>0.34</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawAllRoutesXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+          </Route>
+          <Route>
+            <RouteNo>98</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Westbound</Direction>
+            <RouteHeading>Tunney's Pasture</RouteHeading>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	tests := map[string]string{
+		"06":  "6",
+		"6":   "6",
+		"R1":  "R1",
+		"095": "95",
+		" 7 ": "7",
+		"0":   "0",
+		"":    "",
+	}
+	for in, want := range tests {
+		if got := NormalizeRouteNo(in); got != want {
+			t.Fatalf("Unexpected NormalizeRouteNo(%q): %q", in, got)
+		}
+	}
+
+	var routeNo string
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/GetNextTripsForStop" {
+			routeNo = r.FormValue("routeNo")
+			fmt.Fprint(w, rawXMLString)
+			return
+		}
+		fmt.Fprint(w, rawAllRoutesXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	serves, err := c.StopServicesRoute(context.TODO(), "3020", "097")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serves {
+		t.Fatal("Expected route 097 not to match without WithRouteNoNormalization")
+	}
+
+	c = NewConnection("", "", WithRouteNoNormalization())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err = c.GetNextTripsForStop(context.TODO(), "094", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routeNo != "94" {
+		t.Fatalf("Unexpected routeNo sent to the API: %v", routeNo)
+	}
+	serves, err = c.StopServicesRoute(context.TODO(), "3020", "097")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !serves {
+		t.Fatal("Expected route 097 to match route 97 with WithRouteNoNormalization")
+	}
+	nextTrips, err := c.NextTrips("3020").Route("098").Do(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nextTrips.Routes) != 1 || nextTrips.Routes[0].RouteNo != "98" {
+		t.Fatal("Unexpected routes from NextTripsRequest with WithRouteNoNormalization")
+	}
+}
+
+func TestRouteSummaryChanged(t *testing.T) {
+	old := &RouteSummaryForStop{StopNo: "7659", Routes: []Route{
+		{RouteNo: "6", DirectionID: "1", Direction: "Northbound", RouteHeading: "Rockcliffe"},
+		{RouteNo: "7", DirectionID: "1", Direction: "Eastbound", RouteHeading: "St-Laurent"},
+	}}
+	new := &RouteSummaryForStop{StopNo: "7659", Routes: []Route{
+		{RouteNo: "6", DirectionID: "1", Direction: "Northbound", RouteHeading: "Rockcliffe"},
+		{RouteNo: "10", DirectionID: "0", Direction: "Southbound", RouteHeading: "Hurdman"},
+	}}
+
+	if RouteSummaryChanged(old, old) {
+		t.Fatal("Expected no change between identical summaries")
+	}
+	if !RouteSummaryChanged(old, new) {
+		t.Fatal("Expected a change between summaries differing by a route")
+	}
+	added, removed := DiffRouteSummaries(old, new)
+	if len(added) != 1 || added[0].RouteNo != "10" {
+		t.Fatal("Unexpected added routes from DiffRouteSummaries")
+	}
+	if len(removed) != 1 || removed[0].RouteNo != "7" {
+		t.Fatal("Unexpected removed routes from DiffRouteSummaries")
+	}
+	added, removed = DiffRouteSummaries(nil, old)
+	if len(added) != 2 || removed != nil {
+		t.Fatal("Unexpected diff from a nil summary")
+	}
+}
+
+func TestCountdownPolicy(t *testing.T) {
+	tests := []struct {
+		policy CountdownPolicy
+		want   [3]string
+	}{
+		{CountdownDue, [3]string{"Due", "1 min", "5 min"}},
+		{CountdownDueWithinMinute, [3]string{"Due", "Due", "5 min"}},
+		{CountdownArriving, [3]string{"Arriving", "Due", "5 min"}},
+		{CountdownLessThanMinute, [3]string{"<1 min", "1 min", "5 min"}},
+	}
+	for _, test := range tests {
+		for i, minutes := range []int{0, 1, 5} {
+			got := Trip{AdjustedScheduleTime: minutes}.CountdownWith(test.policy)
+			if got != test.want[i] {
+				t.Fatalf("Unexpected CountdownWith(%v) for %v minutes: %v", test.policy, minutes, got)
+			}
+		}
+	}
+
+	c := NewConnection("", "", WithCountdownPolicy(CountdownArriving))
+	if got := c.Countdown(Trip{AdjustedScheduleTime: 0}); got != "Arriving" {
+		t.Fatalf("Unexpected Countdown with CountdownArriving: %v", got)
+	}
+	if got := c.Countdown(Trip{AdjustedScheduleTime: 1}); got != "Due" {
+		t.Fatalf("Unexpected Countdown with CountdownArriving: %v", got)
+	}
+	if got := NewConnection("", "").Countdown(Trip{AdjustedScheduleTime: 1}); got != "1 min" {
+		t.Fatalf("Unexpected Countdown without WithCountdownPolicy: %v", got)
+	}
+}
+
+func TestBusTypes(t *testing.T) {
+	nextTripsAllRoutes := NextTripsForStopAllRoutes{
+		Routes: []RouteWithTrips{
+			{RouteNo: "97", Trips: []Trip{{BusType: "6EB - 60"}, {BusType: "4LB - DD"}, {BusType: " - DD"}}},
+			{RouteNo: "98", Trips: []Trip{{BusType: "6EB - 60"}, {BusType: ""}, {BusType: "6EAB - 60"}}},
+		},
+	}
+
+	busTypes := nextTripsAllRoutes.BusTypes()
+	want := []string{"6EB - 60", "4LB - DD", "- DD", "6EAB - 60"}
+	if len(busTypes) != len(want) {
+		t.Fatalf("Unexpected BusTypes: %q", busTypes)
+	}
+	for i := range want {
+		if busTypes[i] != want[i] {
+			t.Fatalf("Unexpected BusTypes: %q", busTypes)
+		}
+	}
+	if (&NextTripsForStopAllRoutes{}).BusTypes() != nil {
+		t.Fatal("Expected no BusTypes without any routes")
+	}
+}
+
+func TestDecodeMislabeledCharset(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	latin1 := strings.ReplaceAll(rawXMLString, "é", "\xE9")
+	bodies := map[string]string{
+		"Latin-1 labeled as UTF-8":   latin1,
+		"Latin-1 labeled as Latin-1": strings.Replace(latin1, `encoding="utf-8"`, `encoding="ISO-8859-1"`, 1),
+		"UTF-8":                      rawXMLString,
+	}
+	for name, body := range bodies {
+		rawHandler := func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		}
+		ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+
+		c := NewConnection("", "")
+		c.cAPIURLPrefix = ts.URL + "/"
+
+		nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+		ts.Close()
+		if err != nil {
+			t.Fatalf("Unexpected error decoding a %v response: %v", name, err)
+		}
+		if nextTripsAllRoutes.Routes[0].RouteHeading != "Airport / Aéroport" {
+			t.Fatalf("Unexpected RouteHeading from a %v response: %q", name, nextTripsAllRoutes.Routes[0].RouteHeading)
+		}
+	}
+}
+
+func TestBoard(t *testing.T) {
+	nextTripsAllRoutes := NextTripsForStopAllRoutes{
+		Routes: []RouteWithTrips{
+			{RouteNo: "97", Trips: []Trip{
+				{TripDestination: "Airport / Aéroport", AdjustedScheduleTime: 8, AdjustmentAge: 0.42},
+				{TripDestination: "Airport / Aéroport", AdjustedScheduleTime: 22, AdjustmentAge: -1},
+			}},
+			{RouteNo: "97", Trips: []Trip{
+				{TripDestination: "Bayshore", AdjustedScheduleTime: 2, AdjustmentAge: 0.44},
+				{TripDestination: "Bells Corners", AdjustedScheduleTime: 15, AdjustmentAge: 0.51},
+			}},
+			{RouteNo: "98", Trips: []Trip{
+				{TripDestination: "LeBreton", AdjustedScheduleTime: 14, AdjustmentAge: 0.37},
+			}},
+		},
+	}
+
+	board := nextTripsAllRoutes.Board(4)
+	want := []BoardRow{
+		{RouteNo: "97", Destination: "Bayshore", MinutesAway: 2, RealTime: true},
+		{RouteNo: "97", Destination: "Airport / Aéroport", MinutesAway: 8, RealTime: true},
+		{RouteNo: "98", Destination: "LeBreton", MinutesAway: 14, RealTime: true},
+		{RouteNo: "97", Destination: "Bells Corners", MinutesAway: 15, RealTime: true},
+	}
+	if len(board) != len(want) {
+		t.Fatalf("Unexpected number of rows in returned Board: %v", len(board))
+	}
+	for i := range want {
+		if board[i] != want[i] {
+			t.Fatalf("Unexpected row %v in returned Board: %+v", i, board[i])
+		}
+	}
+
+	all := nextTripsAllRoutes.Board(0)
+	if len(all) != 5 {
+		t.Fatalf("Unexpected number of rows in an uncapped Board: %v", len(all))
+	}
+	if all[4].MinutesAway != 22 || all[4].RealTime {
+		t.Fatal("Unexpected rows in an uncapped Board")
+	}
+}
+
+func TestWithFailFast(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(2)
+	canceled := make(chan string, 2)
+	done := make(chan struct{})
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		stopNo := r.FormValue("stopNo")
+		if stopNo == "3020" {
+			started.Wait()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		started.Done()
+		select {
+		case <-r.Context().Done():
+			canceled <- stopNo
+		case <-done:
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+	defer close(done)
+
+	c := NewConnection("", "", WithFailFast())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.TripsOnRoute(context.TODO(), "94", []string{"3020", "3021", "3022"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected the failed request's StatusError, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-canceled:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the outstanding requests to be canceled")
+		}
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	toronto, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd := RouteDirection{RequestProcessingTime: time.Date(2018, 8, 31, 11, 40, 42, 0, toronto)}
+
+	now := time.Date(2018, 8, 31, 15, 41, 12, 0, time.UTC)
+	if skew := rd.ClockSkew(now); skew != 30*time.Second {
+		t.Fatalf("Unexpected ClockSkew: %v", skew)
+	}
+	if skew := rd.ClockSkew(now.Add(-time.Minute)); skew != -30*time.Second {
+		t.Fatalf("Unexpected ClockSkew for a client clock behind the server's: %v", skew)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	var stopNos []string
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Method]++
+		stopNos = append(stopNos, r.FormValue("stopNo"))
+		attempt := requests[r.Method]
+		mu.Unlock()
+		if r.Method == "POST" && attempt == 2 {
+			fmt.Fprint(w, rawXMLString)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "", WithRetry(3, time.Millisecond, RetryGET))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetGTFSAgency(context.TODO())
+	if err == nil {
+		t.Fatal("Expected error after retrying a GET")
+	}
+	if requests["GET"] != 3 {
+		t.Fatalf("Expected a GET to be attempted 3 times, got %v", requests["GET"])
+	}
+	_, err = c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err == nil {
+		t.Fatal("Expected error from a POST which isn't retried")
+	}
+	if requests["POST"] != 1 {
+		t.Fatalf("Expected a POST to be attempted once under a GET only policy, got %v", requests["POST"])
+	}
+
+	c = NewConnection("", "", WithRetry(3, time.Millisecond, RetryGET|RetryPOST))
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTripsAllRoutes.StopNo != "3020" || requests["POST"] != 2 {
+		t.Fatal("Expected a POST to be retried until it succeeded")
+	}
+	if stopNos[len(stopNos)-1] != "3020" {
+		t.Fatal("Expected the retried POST to resend its body")
+	}
+
+	c = NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err = c.GetGTFSAgency(context.TODO())
+	if err == nil || requests["GET"] != 4 {
+		t.Fatal("Expected no retries without WithRetry")
+	}
+}
+
+func TestWithBaseContext(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	done := make(chan struct{})
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("stopNo") == "3021" {
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+	defer close(done)
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutesSimple("3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTripsAllRoutes.StopNo != "3020" {
+		t.Fatal("Unexpected StopNo in returned NextTripsForStopAllRoutes")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	base := NewConnection("", "", WithBaseContext(ctx))
+	base.cAPIURLPrefix = ts.URL + "/"
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = base.GetNextTripsForStopAllRoutesSimple("3021")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the base context's cancellation to propagate, got %v", err)
+	}
+	_, err = base.GetRouteSummaryForStopSimple("3020")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected error with a canceled base context, got %v", err)
+	}
+}
+
+func TestErrorAttribute(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult error="TestErrorStringHere">
+        <StopNo xmlns="http://tempuri.org/">7659</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">BANK / FIFTH</StopDescription>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawNextTripsXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult error="10"/>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawAllRoutesXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult error="TestAllRoutesErrorHere">
+        <StopNo xmlns="http://tempuri.org/">7659</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">BANK / FIFTH</StopDescription>
+        <Routes xmlns="http://tempuri.org/"/>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/GetNextTripsForStop":
+			fmt.Fprint(w, rawNextTripsXMLString)
+		case "/GetNextTripsForStopAllRoutes":
+			fmt.Fprint(w, rawAllRoutesXMLString)
+		default:
+			fmt.Fprint(w, rawXMLString)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	routeSummary, err := c.GetRouteSummaryForStop(context.TODO(), "7659")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routeSummary.Error != "TestErrorStringHere" {
+		t.Fatal("Unexpected Error in returned RouteSummaryForStop")
+	}
+
+	_, err = c.GetNextTripsForStop(context.TODO(), "6", "7659")
+	if err != ErrInvalidStop {
+		t.Fatalf("Expected ErrInvalidStop from an error attribute, got %v", err)
+	}
+
+	nextTripsAllRoutes, err := c.GetNextTripsForStopAllRoutes(context.TODO(), "7659")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTripsAllRoutes.Error != "TestAllRoutesErrorHere" {
+		t.Fatal("Unexpected Error in returned NextTripsForStopAllRoutes")
+	}
+}
+
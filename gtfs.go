@@ -1,389 +1,1974 @@
 package gooctranspoapi
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// gtfsDateLayout is the layout of dates in the GTFS tables.
+const gtfsDateLayout = "20060102"
+
 // ID will setup the request to return a specific row in a table by the id value.
-func ID(id string) func(url.Values) error {
-	return func(v url.Values) error {
-		v.Set("id", id)
+func ID(id string) RequestOption {
+	return func(r *requestSetup) error {
+		r.values.Set("id", id)
 		return nil
 	}
 }
 
 // ColumnAndValue will setup the request to return data from a specific column and value.
-func ColumnAndValue(column, value string) func(url.Values) error {
-	return func(v url.Values) error {
-		v.Set("column", column)
-		v.Set("value", value)
+func ColumnAndValue(column, value string) RequestOption {
+	return func(r *requestSetup) error {
+		r.values.Set("column", column)
+		r.values.Set("value", value)
 		return nil
 	}
 }
 
 // OrderBy will setup the request to sort the data by a specific column.
-func OrderBy(orderBy string) func(url.Values) error {
-	return func(v url.Values) error {
-		v.Set("orderBy", orderBy)
+func OrderBy(orderBy string) RequestOption {
+	return func(r *requestSetup) error {
+		r.values.Set("orderBy", orderBy)
 		return nil
 	}
 }
 
 // Direction will setup the request to direction of sorted records, asc and desc.
-func Direction(direction string) func(url.Values) error {
-	return func(v url.Values) error {
+func Direction(direction string) RequestOption {
+	return func(r *requestSetup) error {
 		if direction != "asc" && direction != "desc" {
 			return errors.New("direction only accepts asc or desc as parameters")
 		}
-		v.Set("direction", direction)
+		r.values.Set("direction", direction)
 		return nil
 	}
 }
 
 // Limit will setup the request to only return a maximum number of records.
-func Limit(limit int) func(url.Values) error {
-	return func(v url.Values) error {
-		v.Set("limit", strconv.Itoa(limit))
+func Limit(limit int) RequestOption {
+	return func(r *requestSetup) error {
+		r.values.Set("limit", strconv.Itoa(limit))
 		return nil
 	}
 }
 
-func setTable(table string) func(url.Values) error {
-	return func(v url.Values) error {
-		v.Set("table", table)
+func setTable(table string) RequestOption {
+	return func(r *requestSetup) error {
+		r.values.Set("table", table)
 		return nil
 	}
 }
 
-func (c Connection) setupGTFSURL(options ...func(url.Values) error) (*url.URL, error) {
-	u, err := url.Parse(c.cAPIURLPrefix + "Gtfs")
+// GTFSQueryBuilder builds a GTFS request with chainable methods,
+// as an alternative to passing functional options.
+// The zero value is an empty query, ready to use.
+type GTFSQueryBuilder struct {
+	options []RequestOption
+}
+
+// Table sets the GTFS table to query.
+func (b *GTFSQueryBuilder) Table(table string) *GTFSQueryBuilder {
+	b.options = append(b.options, setTable(table))
+	return b
+}
+
+// Where will setup the query to return data from a specific column and value.
+func (b *GTFSQueryBuilder) Where(column, value string) *GTFSQueryBuilder {
+	b.options = append(b.options, ColumnAndValue(column, value))
+	return b
+}
+
+// OrderBy will setup the query to sort the data by a specific column.
+func (b *GTFSQueryBuilder) OrderBy(orderBy string) *GTFSQueryBuilder {
+	b.options = append(b.options, OrderBy(orderBy))
+	return b
+}
+
+// Direction will setup the query to direction of sorted records, asc and desc.
+func (b *GTFSQueryBuilder) Direction(direction string) *GTFSQueryBuilder {
+	b.options = append(b.options, Direction(direction))
+	return b
+}
+
+// Limit will setup the query to only return a maximum number of records.
+func (b *GTFSQueryBuilder) Limit(limit int) *GTFSQueryBuilder {
+	b.options = append(b.options, Limit(limit))
+	return b
+}
+
+// Options returns the query as functional options,
+// which can be passed to any of the GTFS methods.
+func (b *GTFSQueryBuilder) Options() []RequestOption {
+	return append([]RequestOption(nil), b.options...)
+}
+
+// Values returns the query parameters set by the builder.
+// An error is returned if any of the chained values were invalid.
+func (b *GTFSQueryBuilder) Values() (url.Values, error) {
+	v := url.Values{}
+	_, err := applyOptions(v, b.options)
 	if err != nil {
 		return nil, err
 	}
+	return v, nil
+}
+
+// Query returns the query set by the builder as a GTFSQuery,
+// for example to look up its CacheKey before making the request.
+func (b *GTFSQueryBuilder) Query() (GTFSQuery, error) {
+	v, err := b.Values()
+	if err != nil {
+		return GTFSQuery{}, err
+	}
+	return GTFSQuery{
+		Table:     v.Get("table"),
+		Direction: v.Get("direction"),
+		Column:    v.Get("column"),
+		Value:     v.Get("value"),
+		ID:        v.Get("id"),
+		OrderBy:   v.Get("orderBy"),
+		Limit:     GTFSLimit(v.Get("limit")),
+		Format:    "json",
+	}, nil
+}
+
+// GTFSQuery is the query echoed back in GTFS responses.
+type GTFSQuery struct {
+	Table     string    `json:"table"`
+	Direction string    `json:"direction"`
+	Column    string    `json:"column"`
+	Value     string    `json:"value"`
+	ID        string    `json:"id"`
+	OrderBy   string    `json:"orderBy"`
+	Limit     GTFSLimit `json:"limit"`
+	Format    string    `json:"format"`
+}
+
+// GTFSLimit is the limit echoed back in a GTFS query.
+// The API sends it as a number, a quoted number, or an empty string when no limit was set.
+type GTFSLimit string
+
+// UnmarshalJSON accepts the limit as either a JSON number or a string.
+func (l *GTFSLimit) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = GTFSLimit(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*l = GTFSLimit(n)
+	return nil
+}
+
+// CacheKey returns a stable key for the query, suitable for caching responses.
+// Queries which ask for the same data have the same key, regardless of the order
+// the parameters were set in, or the case of the direction.
+// The appID and apiKey are never part of the key.
+func (q GTFSQuery) CacheKey() string {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("table", q.Table)
+	set("direction", strings.ToLower(q.Direction))
+	set("column", q.Column)
+	set("value", q.Value)
+	set("id", q.ID)
+	set("orderBy", q.OrderBy)
+	set("limit", string(q.Limit))
+	return v.Encode()
+}
+
+func (c Connection) setupGTFSURL(options ...RequestOption) (*url.URL, *requestSettings, error) {
+	u, err := url.Parse(c.cAPIURLPrefix + "Gtfs")
+	if err != nil {
+		return nil, nil, c.prefixError(err)
+	}
 	v := url.Values{}
 	v.Set("appID", c.ID)
 	v.Set("apiKey", c.Key)
 	v.Set("format", "json")
-	for _, opt := range options {
-		err := opt(v)
-		if err != nil {
-			return nil, err
-		}
+	settings, err := applyOptions(v, options)
+	if err != nil {
+		return nil, nil, c.prefixError(err)
 	}
 	u.RawQuery = v.Encode()
-	return u, nil
+	return u, settings, nil
 }
 
-func (c Connection) performGTFSRequest(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+func (c Connection) performGTFSRequest(ctx context.Context, u *url.URL, settings *requestSettings) (io.ReadCloser, error) {
+	rng, fields := settings.columnRange, settings.fields
+	ctx = settings.context(ctx)
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
-		return nil, err
+		return nil, c.prefixError(err)
 	}
+	req.Header.Set("Accept", "application/json")
 	req = req.WithContext(ctx)
-	req.Close = true
+	c.logRequest("GET", *u, u.Query())
 
-	err = c.Limiter.Wait(ctx)
+	body, err := c.send(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-
-	resp, err := c.HTTPClient.Do(req)
-	if err != nil {
-		if resp != nil {
-			resp.Body.Close()
+	if rng == nil && fields == nil {
+		return body, nil
+	}
+	var rows io.Reader = body
+	if rng != nil {
+		rows, err = rng.filter(rows)
+		if err != nil {
+			body.Close()
+			return nil, c.prefixError(err)
 		}
-		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		resp.Body.Close()
-		return nil, fmt.Errorf("Non 200 HTTP response from API. %v %v", resp.Status, u.String())
+	if fields != nil {
+		rows, err = trimFields(rows, fields)
+		if err != nil {
+			body.Close()
+			return nil, c.prefixError(err)
+		}
 	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{rows, body}, nil
+}
 
-	return resp.Body, nil
+// ColumnRange will setup the request to only return rows where the column's value
+// is between min and max, inclusive. The API doesn't support range queries,
+// so the rows are filtered by the client after they're fetched. Values are compared
+// as GTFS times, such as "17:05:00", or as numbers, when both sides parse as one,
+// otherwise as strings. Since the filtering happens after the request, ColumnRange
+// should be combined with ColumnAndValue to avoid fetching large tables,
+// for example the stop_times of a stop_id with arrival_time in a range.
+func ColumnRange(column, min, max string) RequestOption {
+	return func(r *requestSetup) error {
+		if column == "" {
+			return errors.New("a column must be specified for the range")
+		}
+		r.settings.columnRange = &columnRange{column: column, min: min, max: max}
+		return nil
+	}
 }
 
-// GTFSAgency is the GTFS agency table.
-type GTFSAgency struct {
-	Query struct {
-		Table     string `json:"table"`
-		Direction string `json:"direction"`
-		Format    string `json:"format"`
-	} `json:"Query"`
-	Gtfs []struct {
-		ID             string `json:"id"`
-		AgencyName     string `json:"agency_name"`
-		AgencyURL      string `json:"agency_url"`
-		AgencyTimezone string `json:"agency_timezone"`
-		AgencyLang     string `json:"agency_lang"`
-		AgencyPhone    string `json:"agency_phone"`
-	} `json:"Gtfs"`
+// columnRange is a range of values which the rows of a GTFS table are filtered to.
+type columnRange struct {
+	column, min, max string
 }
 
-// GetGTFSAgency returns the GTFS agency table.
-func (c Connection) GetGTFSAgency(ctx context.Context, options ...func(url.Values) error) (*GTFSAgency, error) {
-	options = append(options, setTable("agency"))
-	u, err := c.setupGTFSURL(options...)
+// filter returns a copy of the GTFS response with only the rows in the range.
+func (rng *columnRange) filter(body io.Reader) (io.Reader, error) {
+	return rewriteGTFSRows(body, func(rows []map[string]json.RawMessage) []map[string]json.RawMessage {
+		filtered := []map[string]json.RawMessage{}
+		for _, row := range rows {
+			var value string
+			if json.Unmarshal(row[rng.column], &value) != nil {
+				value = string(row[rng.column])
+			}
+			if compareGTFSValues(value, rng.min) >= 0 && compareGTFSValues(value, rng.max) <= 0 {
+				filtered = append(filtered, row)
+			}
+		}
+		return filtered
+	})
+}
+
+// rewriteGTFSRows returns a copy of the GTFS response, with its rows replaced by those returned by fn.
+func rewriteGTFSRows(body io.Reader, fn func(rows []map[string]json.RawMessage) []map[string]json.RawMessage) (io.Reader, error) {
+	response := make(map[string]json.RawMessage)
+	err := json.NewDecoder(body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]json.RawMessage
+	if response["Gtfs"] != nil {
+		err = json.Unmarshal(response["Gtfs"], &rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+	response["Gtfs"], err = json.Marshal(fn(rows))
 	if err != nil {
 		return nil, err
 	}
-	respBody, err := c.performGTFSRequest(ctx, u)
+	encoded, err := json.Marshal(response)
 	if err != nil {
 		return nil, err
 	}
-	data := &GTFSAgency{}
-	err = json.NewDecoder(respBody).Decode(data)
-	respBody.Close()
-	return data, err
+	return bytes.NewReader(encoded), nil
 }
 
-// GTFSCalendar is the GTFS calendar table.
-type GTFSCalendar struct {
-	Query struct {
-		Table     string `json:"table"`
-		Direction string `json:"direction"`
-		Format    string `json:"format"`
-	} `json:"Query"`
-	Gtfs []struct {
-		ID        string `json:"id"`
-		ServiceID string `json:"service_id"`
-		Monday    string `json:"monday"`
-		Tuesday   string `json:"tuesday"`
-		Wednesday string `json:"wednesday"`
-		Thursday  string `json:"thursday"`
-		Friday    string `json:"friday"`
-		Saturday  string `json:"saturday"`
-		Sunday    string `json:"sunday"`
-		StartDate string `json:"start_date"`
-		EndDate   string `json:"end_date"`
-	} `json:"Gtfs"`
+// Fields will setup the request to only keep the given columns of each row,
+// such as "stop_id" and "stop_name". The API doesn't support choosing columns,
+// so the other columns are dropped by the client after the rows are fetched,
+// and are left empty in the returned rows, which still have every field.
+// With WithGTFSValidation, the columns it requires should be included.
+func Fields(cols ...string) RequestOption {
+	return func(r *requestSetup) error {
+		if len(cols) == 0 {
+			return errors.New("at least one field must be specified")
+		}
+		r.settings.fields = append([]string(nil), cols...)
+		return nil
+	}
 }
 
-// GetGTFSCalendar returns the GTFS calendar table.
-func (c Connection) GetGTFSCalendar(ctx context.Context, options ...func(url.Values) error) (*GTFSCalendar, error) {
-	options = append(options, setTable("calendar"))
-	u, err := c.setupGTFSURL(options...)
-	if err != nil {
-		return nil, err
+// trimFields returns a copy of the GTFS response with only the fields kept in each row.
+func trimFields(body io.Reader, fields []string) (io.Reader, error) {
+	return rewriteGTFSRows(body, func(rows []map[string]json.RawMessage) []map[string]json.RawMessage {
+		trimmed := []map[string]json.RawMessage{}
+		for _, row := range rows {
+			kept := make(map[string]json.RawMessage)
+			for _, f := range fields {
+				if value, ok := row[f]; ok {
+					kept[f] = value
+				}
+			}
+			trimmed = append(trimmed, kept)
+		}
+		return trimmed
+	})
+}
+
+// compareGTFSValues compares two GTFS values as times, numbers, or strings,
+// returning -1, 0 or 1.
+func compareGTFSValues(a, b string) int {
+	at, aErr := ParseGTFSTime(a)
+	bt, bErr := ParseGTFSTime(b)
+	if aErr == nil && bErr == nil {
+		return compareFloats(float64(at), float64(bt))
 	}
-	respBody, err := c.performGTFSRequest(ctx, u)
-	if err != nil {
-		return nil, err
+	af, aErr := strconv.ParseFloat(a, 64)
+	bf, bErr := strconv.ParseFloat(b, 64)
+	if aErr == nil && bErr == nil {
+		return compareFloats(af, bf)
 	}
-	data := &GTFSCalendar{}
-	err = json.NewDecoder(respBody).Decode(data)
-	respBody.Close()
-	return data, err
+	return strings.Compare(a, b)
 }
 
-// GTFSCalendarDates is GTFS calendar_dates table.
-type GTFSCalendarDates struct {
-	Query struct {
-		Table     string `json:"table"`
-		Direction string `json:"direction"`
-		Format    string `json:"format"`
-	} `json:"Query"`
-	Gtfs []struct {
-		ID            string `json:"id"`
-		ServiceID     string `json:"service_id"`
-		Date          string `json:"date"`
-		ExceptionType string `json:"exception_type"`
-	} `json:"Gtfs"`
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
 }
 
-// GetGTFSCalendarDates returns the GTFS calendar_dates table
-func (c Connection) GetGTFSCalendarDates(ctx context.Context, options ...func(url.Values) error) (*GTFSCalendarDates, error) {
-	options = append(options, setTable("calendar_dates"))
-	u, err := c.setupGTFSURL(options...)
-	if err != nil {
-		return nil, err
+// ErrSchemaDrift is returned when WithGTFSValidation is set, and a required
+// GTFS column is empty in every row of a response. This usually means the API
+// has renamed the column.
+var ErrSchemaDrift = errors.New("required GTFS column missing from response")
+
+// WithGTFSValidation checks GTFS responses for required columns, such as id
+// and the table's key, which are empty in every row. Without validation,
+// a renamed column silently decodes as empty strings.
+func WithGTFSValidation() ConnectionOption {
+	return func(c *Connection) {
+		c.gtfsValidation = true
+	}
+}
+
+// WithExtraFields keeps the columns of GTFS rows which the row types don't have,
+// in each row's Extra field, so columns added to the API aren't silently lost.
+func WithExtraFields() ConnectionOption {
+	return func(c *Connection) {
+		c.extraFields = true
+	}
+}
+
+// captureExtraFields sets the Extra field of each row of the GTFS table in data,
+// to the columns in the raw response which the row type doesn't have.
+func captureExtraFields(raw []byte, data interface{}) error {
+	rows := reflect.Indirect(reflect.ValueOf(data)).FieldByName("Gtfs")
+	if !rows.IsValid() || rows.Kind() != reflect.Slice || rows.Type().Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	rowType := rows.Type().Elem()
+	extraField, ok := rowType.FieldByName("Extra")
+	if !ok {
+		return nil
 	}
-	respBody, err := c.performGTFSRequest(ctx, u)
+	known := make(map[string]bool)
+	for i := 0; i < rowType.NumField(); i++ {
+		known[strings.Split(rowType.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+
+	rawRows := struct {
+		Gtfs []map[string]json.RawMessage `json:"Gtfs"`
+	}{}
+	err := json.Unmarshal(raw, &rawRows)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	data := &GTFSCalendarDates{}
-	err = json.NewDecoder(respBody).Decode(data)
+	for i, rawRow := range rawRows.Gtfs {
+		if i >= rows.Len() {
+			break
+		}
+		extra := make(map[string]json.RawMessage)
+		for column, value := range rawRow {
+			if !known[column] {
+				extra[column] = value
+			}
+		}
+		if len(extra) > 0 {
+			rows.Index(i).FieldByIndex(extraField.Index).Set(reflect.ValueOf(extra))
+		}
+	}
+	return nil
+}
+
+// decodeGTFS decodes a GTFS response into data and closes the response body.
+// If validation is on, the required columns are checked.
+func (c Connection) decodeGTFS(respBody io.ReadCloser, data interface{}, required ...string) error {
+	var body io.Reader = respBody
+	var raw bytes.Buffer
+	if c.extraFields {
+		body = io.TeeReader(respBody, &raw)
+	}
+	err := json.NewDecoder(body).Decode(data)
 	respBody.Close()
-	return data, err
+	if err != nil {
+		return c.prefixError(err)
+	}
+	if c.extraFields {
+		err = captureExtraFields(raw.Bytes(), data)
+		if err != nil {
+			return c.prefixError(err)
+		}
+	}
+	if !c.gtfsValidation {
+		return nil
+	}
+	return c.prefixError(checkRequiredColumns(data, required))
 }
 
-// GTFSRoutes is the GTFS routes table.
-type GTFSRoutes struct {
-	Query struct {
-		Table     string `json:"table"`
-		Direction string `json:"direction"`
-		Format    string `json:"format"`
-	} `json:"Query"`
-	Gtfs []struct {
-		ID             string `json:"id"`
-		RouteID        string `json:"route_id"`
-		RouteShortName string `json:"route_short_name"`
-		RouteLongName  string `json:"route_long_name"`
-		RouteDesc      string `json:"route_desc"`
-		RouteType      string `json:"route_type"`
-	} `json:"Gtfs"`
+// checkRequiredColumns returns ErrSchemaDrift if any of the required columns
+// is empty in every row of the GTFS table.
+func checkRequiredColumns(data interface{}, required []string) error {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	rows := v.FieldByName("Gtfs")
+	if !rows.IsValid() || rows.Kind() != reflect.Slice || rows.Len() == 0 || rows.Type().Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	rowType := rows.Type().Elem()
+	for _, column := range required {
+		field := -1
+		for i := 0; i < rowType.NumField(); i++ {
+			if strings.Split(rowType.Field(i).Tag.Get("json"), ",")[0] == column {
+				field = i
+			}
+		}
+		if field == -1 {
+			continue
+		}
+		found := false
+		for i := 0; i < rows.Len(); i++ {
+			if rows.Index(i).Field(field).String() != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %v", ErrSchemaDrift, column)
+		}
+	}
+	return nil
 }
 
-// GetGTFSRoutes returns the GTFS routes table.
-func (c Connection) GetGTFSRoutes(ctx context.Context, options ...func(url.Values) error) (*GTFSRoutes, error) {
-	options = append(options, setTable("routes"))
-	u, err := c.setupGTFSURL(options...)
+// pingTimeout is the longest Ping will wait for a response.
+const pingTimeout = 5 * time.Second
+
+var (
+	// ErrUnreachable is returned by Ping when the API can't be reached.
+	ErrUnreachable = errors.New("unable to reach the API")
+	// ErrBadCredentials is returned by Ping when the API rejects the appID or apiKey.
+	ErrBadCredentials = errors.New("the API rejected the appID or apiKey")
+	// ErrMalformedResponse is returned by Ping when the API's response can't be understood.
+	ErrMalformedResponse = errors.New("malformed response from the API")
+)
+
+// Ping makes a minimal request to the API, the first row of the GTFS agency table,
+// to check that the API is reachable and the appID and apiKey are accepted.
+// Ping waits at most five seconds, or less if the context has an earlier deadline.
+// Failures are returned as ErrUnreachable, ErrBadCredentials or ErrMalformedResponse,
+// which can be checked for with errors.Is.
+func (c Connection) Ping(ctx context.Context) error {
+	// The error is prefixed once here, rather than by each step of the request.
+	bare := c
+	bare.errorPrefix = ""
+	return c.prefixError(bare.ping(ctx))
+}
+
+// ping makes Ping's request, leaving its errors without the connection's error prefix.
+func (c Connection) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	u, settings, err := c.setupGTFSURL(setTable("agency"), Limit(1))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	respBody, err := c.performGTFSRequest(ctx, u)
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
 	if err != nil {
-		return nil, err
+		var se *StatusError
+		if errors.As(err, &se) && (se.StatusCode == http.StatusUnauthorized || se.StatusCode == http.StatusForbidden) {
+			return ErrBadCredentials
+		}
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			return fmt.Errorf("%w: %v", ErrUnreachable, err)
+		}
+		if errors.Is(err, ErrEmptyResponse) {
+			return fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+		}
+		return err
 	}
-	data := &GTFSRoutes{}
+	data := &GTFSAgency{}
 	err = json.NewDecoder(respBody).Decode(data)
 	respBody.Close()
-	return data, err
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+	if data.Query.Table != "agency" {
+		return ErrMalformedResponse
+	}
+	return nil
 }
 
-// GTFSStops is the GTFS stops table.
-type GTFSStops struct {
-	Query struct {
-		Table     string `json:"table"`
-		Direction string `json:"direction"`
-		Column    string `json:"column"`
-		Value     string `json:"value"`
-		Format    string `json:"format"`
-	} `json:"Query"`
-	Gtfs []struct {
-		ID            string `json:"id"`
-		StopID        string `json:"stop_id"`
-		StopCode      string `json:"stop_code"`
-		StopName      string `json:"stop_name"`
-		StopDesc      string `json:"stop_desc"`
-		StopLat       string `json:"stop_lat"`
-		StopLon       string `json:"stop_lon"`
-		ZoneID        string `json:"zone_id"`
-		StopURL       string `json:"stop_url"`
-		LocationType  string `json:"location_type"`
-		ParentStation string `json:"parent_station"`
-	} `json:"Gtfs"`
+// GetGTFSTable performs the query built by q, and decodes the response into data,
+// which should be a pointer to the GTFS type matching the queried table, such as *GTFSRoutes.
+func (c Connection) GetGTFSTable(ctx context.Context, q *GTFSQueryBuilder, data interface{}) error {
+	v, err := q.Values()
+	if err != nil {
+		return c.prefixError(err)
+	}
+	if v.Get("table") == "" {
+		return c.prefixError(errors.New("a table must be specified"))
+	}
+	u, settings, err := c.setupGTFSURL(q.Options()...)
+	if err != nil {
+		return err
+	}
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
+	if err != nil {
+		return err
+	}
+	return c.decodeGTFS(respBody, data, "id")
 }
 
-// GetGTFSStops returns the GTFS stops table.
-// It requires a stop_id, stop_code or id value specified, using ColumnAndValue() or ID() options.
-func (c Connection) GetGTFSStops(ctx context.Context, options ...func(url.Values) error) (*GTFSStops, error) {
-	options = append(options, setTable("stops"))
-	u, err := c.setupGTFSURL(options...)
+// ErrCountUnavailable is returned by CountGTFSRows when the response doesn't include a row count.
+var ErrCountUnavailable = errors.New("the API didn't return a row count")
+
+// CountGTFSRows returns how many rows of the table match the options, such as
+// ColumnAndValue, for example to estimate the size of a stop_times request before
+// making it. It requests a single row, and reads the total from the response's
+// metadata, either a "total" in the Query, or at the top level. The API doesn't
+// document a total, so ErrCountUnavailable is returned when it's missing.
+func (c Connection) CountGTFSRows(ctx context.Context, table string, options ...RequestOption) (int, error) {
+	options = append(options, setTable(table), Limit(1))
+	u, settings, err := c.setupGTFSURL(options...)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	v, err := url.ParseQuery(u.RawQuery)
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	data := struct {
+		Query struct {
+			Total json.Number `json:"total"`
+		} `json:"Query"`
+		Total json.Number `json:"total"`
+	}{}
+	err = json.NewDecoder(respBody).Decode(&data)
+	respBody.Close()
+	if err != nil {
+		return 0, c.prefixError(err)
 	}
-	if v.Get("column") != "stop_id" && v.Get("column") != "stop_code" && v.Get("id") == "" {
-		return nil, errors.New("a stop_id, stop_code or id value must be specified")
+	total := data.Query.Total
+	if total == "" {
+		total = data.Total
 	}
-	respBody, err := c.performGTFSRequest(ctx, u)
+	if total == "" {
+		return 0, c.prefixError(ErrCountUnavailable)
+	}
+	count, err := strconv.Atoi(total.String())
 	if err != nil {
-		return nil, err
+		return 0, c.prefixError(fmt.Errorf("invalid row count %q", total))
 	}
-	data := &GTFSStops{}
-	err = json.NewDecoder(respBody).Decode(data)
-	respBody.Close()
-	return data, err
+	return count, nil
 }
 
-// GTFSStopTimes is the GTFS stop_times table.
-type GTFSStopTimes struct {
-	Query struct {
-		Table     string `json:"table"`
-		Direction string `json:"direction"`
-		Column    string `json:"column"`
-		Value     string `json:"value"`
-		Format    string `json:"format"`
-	} `json:"Query"`
-	Gtfs []struct {
-		ID            string `json:"id"`
-		TripID        string `json:"trip_id"`
-		ArrivalTime   string `json:"arrival_time"`
-		DepartureTime string `json:"departure_time"`
-		StopID        string `json:"stop_id"`
-		StopSequence  string `json:"stop_sequence"`
-		PickupType    string `json:"pickup_type"`
-		DropOffType   string `json:"drop_off_type"`
-	} `json:"Gtfs"`
-}
+// WriteCSV writes the rows of a GTFS table, such as *GTFSRoutes, to w as CSV.
+// The header row uses the GTFS column names.
+func WriteCSV(w io.Writer, data interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Struct {
+		return errors.New("data must be a GTFS table")
+	}
+	rows := v.FieldByName("Gtfs")
+	if !rows.IsValid() || rows.Kind() != reflect.Slice || rows.Type().Elem().Kind() != reflect.Struct {
+		return errors.New("data must be a GTFS table")
+	}
 
-// GetGTFSStopTimes returns the GTFS stop_times table.
-// It requires a trip_id, stop_code or id value specified, using ColumnAndValue() or ID() options.
-func (c Connection) GetGTFSStopTimes(ctx context.Context, options ...func(url.Values) error) (*GTFSStopTimes, error) {
-	options = append(options, setTable("stop_times"))
-	u, err := c.setupGTFSURL(options...)
-	if err != nil {
-		return nil, err
+	rowType := rows.Type().Elem()
+	var columns []int
+	var header []string
+	for i := 0; i < rowType.NumField(); i++ {
+		name := strings.Split(rowType.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || rowType.Field(i).Type.Kind() != reflect.String {
+			continue
+		}
+		columns = append(columns, i)
+		header = append(header, name)
 	}
-	v, err := url.ParseQuery(u.RawQuery)
+
+	cw := csv.NewWriter(w)
+	err := cw.Write(header)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if v.Get("column") != "trip_id" && v.Get("column") != "stop_id" && v.Get("id") == "" {
-		return nil, errors.New("a trip_id, stop_id or id value must be specified")
+	for i := 0; i < rows.Len(); i++ {
+		record := make([]string, len(columns))
+		for j, col := range columns {
+			record[j] = rows.Index(i).Field(col).String()
+		}
+		err := cw.Write(record)
+		if err != nil {
+			return err
+		}
 	}
-	respBody, err := c.performGTFSRequest(ctx, u)
-	if err != nil {
-		return nil, err
+	cw.Flush()
+	return cw.Error()
+}
+
+// StaticFeed holds the GTFS tables which rarely change, for offline use.
+type StaticFeed struct {
+	Agency        *GTFSAgency
+	Calendar      *GTFSCalendar
+	CalendarDates *GTFSCalendarDates
+	Routes        *GTFSRoutes
+}
+
+// PrefetchStatic fetches the agency, calendar, calendar_dates and routes tables
+// concurrently, subject to the connection's rate limit.
+// If any of the fetches fail, the first error is returned. With WithPartialOnTimeout,
+// the tables fetched before the context's deadline are returned along with the error,
+// and the others are left nil.
+func (c Connection) PrefetchStatic(ctx context.Context) (*StaticFeed, error) {
+	feed := &StaticFeed{}
+	errs, failed := c.fanOut(ctx,
+		func(ctx context.Context) (err error) {
+			feed.Agency, err = c.GetGTFSAgency(ctx)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			feed.Calendar, err = c.GetGTFSCalendar(ctx)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			feed.CalendarDates, err = c.GetGTFSCalendarDates(ctx)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			feed.Routes, err = c.GetGTFSRoutes(ctx)
+			return err
+		},
+	)
+	if failed != nil {
+		return nil, failed
 	}
-	data := &GTFSStopTimes{}
-	err = json.NewDecoder(respBody).Decode(data)
-	respBody.Close()
-	return data, err
+	missing := 0
+	for _, err := range errs {
+		if c.timedOut(err) {
+			missing++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if missing > 0 {
+		return feed, c.partialError(missing, len(errs))
+	}
+	return feed, nil
 }
 
-// GTFSTrips is the GTFS trips table.
-type GTFSTrips struct {
-	Query struct {
-		Table     string `json:"table"`
-		Direction string `json:"direction"`
-		Column    string `json:"column"`
-		Value     string `json:"value"`
-		Format    string `json:"format"`
-	} `json:"Query"`
-	Gtfs []struct {
-		ID           string `json:"id"`
-		RouteID      string `json:"route_id"`
-		ServiceID    string `json:"service_id"`
-		TripID       string `json:"trip_id"`
-		TripHeadsign string `json:"trip_headsign"`
-		DirectionID  string `json:"direction_id"`
-		BlockID      string `json:"block_id"`
-	} `json:"Gtfs"`
+// StopStaticData holds the static data about a stop which an app needs to start up.
+type StopStaticData struct {
+	Stop      GTFSStopRow
+	StopTimes []GTFSStopTimeRow
+	// Routes are the routes serving the stop, from GetRouteSummaryForStop.
+	Routes []Route
 }
 
-// GetGTFSTrips returns the GTFS trips table.
-// It requires a route_id or id value specified, using ColumnAndValue() or ID() options.
-func (c Connection) GetGTFSTrips(ctx context.Context, options ...func(url.Values) error) (*GTFSTrips, error) {
-	options = append(options, setTable("trips"))
-	u, err := c.setupGTFSURL(options...)
+// PrefetchForStop fetches the stop's row and its stop_times concurrently, subject to
+// the connection's rate limit, then the routes serving it, by its stop_code.
+// The routes need the stop_code, which the stop's row holds, so they're fetched after,
+// and are reused from StopServicesRoute's cache when possible.
+// If any of the fetches fail, the first error is returned. With WithPartialOnTimeout,
+// the data fetched before the context's deadline is returned along with the error,
+// and the rest is left empty. The routes can't be fetched without the stop's row.
+func (c Connection) PrefetchForStop(ctx context.Context, stopID string) (*StopStaticData, error) {
+	var stops *GTFSStops
+	var stopTimes *GTFSStopTimes
+	errs, failed := c.fanOut(ctx,
+		func(ctx context.Context) (err error) {
+			stops, err = c.GetGTFSStops(ctx, ColumnAndValue("stop_id", stopID), Limit(1))
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			stopTimes, err = c.GetGTFSStopTimes(ctx, ColumnAndValue("stop_id", stopID))
+			return err
+		},
+	)
+	if failed != nil {
+		return nil, failed
+	}
+	missing := 0
+	for _, err := range errs {
+		if c.timedOut(err) {
+			missing++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := &StopStaticData{}
+	if stopTimes != nil {
+		data.StopTimes = stopTimes.Gtfs
+	}
+	if stops == nil {
+		return data, c.partialError(missing+1, len(errs)+1)
+	}
+	if len(stops.Gtfs) == 0 {
+		return nil, c.prefixError(fmt.Errorf("no stop found with stop_id %v", stopID))
+	}
+	data.Stop = stops.Gtfs[0]
+	total := len(errs)
+	if data.Stop.StopCode != "" {
+		total++
+		summary, err := c.cachedRouteSummary(ctx, data.Stop.StopCode)
+		if c.timedOut(err) {
+			missing++
+		} else if err != nil {
+			return nil, err
+		} else {
+			data.Routes = summary.Routes
+		}
+	}
+	if missing > 0 {
+		return data, c.partialError(missing, total)
+	}
+	return data, nil
+}
+
+// GTFSAgency is the GTFS agency table.
+type GTFSAgency struct {
+	Query GTFSQuery       `json:"Query"`
+	Gtfs  []GTFSAgencyRow `json:"Gtfs"`
+}
+
+// GTFSAgencyRow is a row in the GTFS agency table.
+type GTFSAgencyRow struct {
+	ID             string `json:"id"`
+	AgencyName     string `json:"agency_name"`
+	AgencyURL      string `json:"agency_url"`
+	AgencyTimezone string `json:"agency_timezone"`
+	AgencyLang     string `json:"agency_lang"`
+	AgencyPhone    string `json:"agency_phone"`
+	// Extra holds columns the row type doesn't have, when WithExtraFields is set.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// GetGTFSAgency returns the GTFS agency table.
+func (c Connection) GetGTFSAgency(ctx context.Context, options ...RequestOption) (*GTFSAgency, error) {
+	options = append(options, setTable("agency"))
+	u, settings, err := c.setupGTFSURL(options...)
 	if err != nil {
 		return nil, err
 	}
-	v, err := url.ParseQuery(u.RawQuery)
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
 	if err != nil {
 		return nil, err
 	}
-	if v.Get("column") != "route_id" && v.Get("id") == "" {
-		return nil, errors.New("a route_id or id value must be specified")
+	data := &GTFSAgency{}
+	err = c.decodeGTFS(respBody, data, "id")
+	return data, err
+}
+
+// ResolveTimezone returns the agency_timezone from the GTFS agency table as a location,
+// and caches it on the connection, where it's used to parse times returned by the API.
+// If the timezone can't be resolved, America/Toronto is returned along with the error,
+// and nothing is cached.
+func (c Connection) ResolveTimezone(ctx context.Context) (*time.Location, error) {
+	agency, err := c.GetGTFSAgency(ctx, Limit(1))
+	if err == nil && (len(agency.Gtfs) == 0 || agency.Gtfs[0].AgencyTimezone == "") {
+		err = c.prefixError(errors.New("no agency_timezone in the GTFS agency table"))
+	}
+	var loc *time.Location
+	if err == nil {
+		loc, err = loadLocation(agency.Gtfs[0].AgencyTimezone)
+		err = c.prefixError(err)
 	}
-	respBody, err := c.performGTFSRequest(ctx, u)
 	if err != nil {
-		return nil, err
+		fallback, fallbackErr := torontoLocation()
+		if fallbackErr != nil {
+			return nil, c.prefixError(fallbackErr)
+		}
+		return fallback, err
 	}
-	data := &GTFSTrips{}
-	err = json.NewDecoder(respBody).Decode(data)
-	respBody.Close()
-	return data, err
+	c.agencyTZ.set(loc)
+	return loc, nil
+}
+
+// GTFSCalendar is the GTFS calendar table.
+type GTFSCalendar struct {
+	Query GTFSQuery         `json:"Query"`
+	Gtfs  []GTFSCalendarRow `json:"Gtfs"`
+}
+
+// GTFSCalendarRow is a row in the GTFS calendar table.
+type GTFSCalendarRow struct {
+	ID        string `json:"id"`
+	ServiceID string `json:"service_id"`
+	Monday    string `json:"monday"`
+	Tuesday   string `json:"tuesday"`
+	Wednesday string `json:"wednesday"`
+	Thursday  string `json:"thursday"`
+	Friday    string `json:"friday"`
+	Saturday  string `json:"saturday"`
+	Sunday    string `json:"sunday"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	// Extra holds columns the row type doesn't have, when WithExtraFields is set.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// RunsOn returns true if the service runs on the weekday of the date,
+// and the date is within the start and end dates of the service.
+// Exceptions in the calendar_dates table aren't considered.
+func (r GTFSCalendarRow) RunsOn(date time.Time) bool {
+	d := date.Format(gtfsDateLayout)
+	if d < r.StartDate || d > r.EndDate {
+		return false
+	}
+	days := map[time.Weekday]string{
+		time.Monday:    r.Monday,
+		time.Tuesday:   r.Tuesday,
+		time.Wednesday: r.Wednesday,
+		time.Thursday:  r.Thursday,
+		time.Friday:    r.Friday,
+		time.Saturday:  r.Saturday,
+		time.Sunday:    r.Sunday,
+	}
+	return days[date.Weekday()] == "1"
+}
+
+// GetGTFSCalendar returns the GTFS calendar table.
+func (c Connection) GetGTFSCalendar(ctx context.Context, options ...RequestOption) (*GTFSCalendar, error) {
+	options = append(options, setTable("calendar"))
+	u, settings, err := c.setupGTFSURL(options...)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
+	if err != nil {
+		return nil, err
+	}
+	data := &GTFSCalendar{}
+	err = c.decodeGTFS(respBody, data, "id", "service_id")
+	return data, err
+}
+
+// GTFSCalendarDates is GTFS calendar_dates table.
+type GTFSCalendarDates struct {
+	Query GTFSQuery             `json:"Query"`
+	Gtfs  []GTFSCalendarDateRow `json:"Gtfs"`
+}
+
+// GTFSCalendarDateRow is a row in the GTFS calendar_dates table.
+type GTFSCalendarDateRow struct {
+	ID            string `json:"id"`
+	ServiceID     string `json:"service_id"`
+	Date          string `json:"date"`
+	ExceptionType string `json:"exception_type"`
+	// Extra holds columns the row type doesn't have, when WithExtraFields is set.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// ServiceException is the type of exception in the GTFS calendar_dates table.
+type ServiceException int
+
+const (
+	// ServiceAdded means service has been added for the date.
+	ServiceAdded ServiceException = 1
+	// ServiceRemoved means service has been removed for the date.
+	ServiceRemoved ServiceException = 2
+)
+
+// Exception returns the typed exception_type of the row.
+// An error is returned if the exception_type isn't a known value.
+func (r GTFSCalendarDateRow) Exception() (ServiceException, error) {
+	switch r.ExceptionType {
+	case "1":
+		return ServiceAdded, nil
+	case "2":
+		return ServiceRemoved, nil
+	default:
+		return 0, fmt.Errorf("unknown exception_type %q", r.ExceptionType)
+	}
+}
+
+// GetGTFSCalendarDates returns the GTFS calendar_dates table
+func (c Connection) GetGTFSCalendarDates(ctx context.Context, options ...RequestOption) (*GTFSCalendarDates, error) {
+	options = append(options, setTable("calendar_dates"))
+	u, settings, err := c.setupGTFSURL(options...)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
+	if err != nil {
+		return nil, err
+	}
+	data := &GTFSCalendarDates{}
+	err = c.decodeGTFS(respBody, data, "id", "service_id")
+	return data, err
+}
+
+// ActiveServiceIDs returns the service_ids which run on the date,
+// combining the calendar and calendar_dates tables.
+// The service_ids are returned sorted.
+func (c Connection) ActiveServiceIDs(ctx context.Context, date time.Time) ([]string, error) {
+	calendar, err := c.GetGTFSCalendar(ctx)
+	if err != nil {
+		return nil, err
+	}
+	calendarDates, err := c.GetGTFSCalendarDates(ctx, ColumnAndValue("date", date.Format(gtfsDateLayout)))
+	if err != nil {
+		return nil, err
+	}
+
+	active := make(map[string]bool)
+	for _, r := range calendar.Gtfs {
+		if r.RunsOn(date) {
+			active[r.ServiceID] = true
+		}
+	}
+	for _, r := range calendarDates.Gtfs {
+		if r.Date != date.Format(gtfsDateLayout) {
+			continue
+		}
+		exception, err := r.Exception()
+		if err != nil {
+			return nil, c.prefixError(err)
+		}
+		switch exception {
+		case ServiceAdded:
+			active[r.ServiceID] = true
+		case ServiceRemoved:
+			delete(active, r.ServiceID)
+		}
+	}
+
+	var serviceIDs []string
+	for id := range active {
+		serviceIDs = append(serviceIDs, id)
+	}
+	sort.Strings(serviceIDs)
+	return serviceIDs, nil
+}
+
+// ServiceExceptionsBetween returns the calendar_dates rows with a date from start to end,
+// inclusive, sorted by date, for example to show the holiday schedules coming up.
+// Only the dates of start and end are used, not their times.
+func (c Connection) ServiceExceptionsBetween(ctx context.Context, start, end time.Time) ([]GTFSCalendarDateRow, error) {
+	calendarDates, err := c.GetGTFSCalendarDates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	last := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+
+	var exceptions []GTFSCalendarDateRow
+	for _, r := range calendarDates.Gtfs {
+		date, err := time.Parse(gtfsDateLayout, r.Date)
+		if err != nil {
+			return nil, c.prefixError(fmt.Errorf("invalid date %q for service_id %v", r.Date, r.ServiceID))
+		}
+		if date.Before(first) || date.After(last) {
+			continue
+		}
+		exceptions = append(exceptions, r)
+	}
+	sort.SliceStable(exceptions, func(i, j int) bool {
+		return exceptions[i].Date < exceptions[j].Date
+	})
+	return exceptions, nil
+}
+
+// GTFSRoutes is the GTFS routes table.
+type GTFSRoutes struct {
+	Query GTFSQuery      `json:"Query"`
+	Gtfs  []GTFSRouteRow `json:"Gtfs"`
+}
+
+// GTFSRouteRow is a row in the GTFS routes table.
+type GTFSRouteRow struct {
+	ID             string `json:"id"`
+	RouteID        string `json:"route_id"`
+	RouteShortName string `json:"route_short_name"`
+	RouteLongName  string `json:"route_long_name"`
+	RouteDesc      string `json:"route_desc"`
+	RouteType      string `json:"route_type"`
+	// Extra holds columns the row type doesn't have, when WithExtraFields is set.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// GetGTFSRoutes returns the GTFS routes table.
+func (c Connection) GetGTFSRoutes(ctx context.Context, options ...RequestOption) (*GTFSRoutes, error) {
+	options = append(options, setTable("routes"))
+	u, settings, err := c.setupGTFSURL(options...)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
+	if err != nil {
+		return nil, err
+	}
+	data := &GTFSRoutes{}
+	err = c.decodeGTFS(respBody, data, "id", "route_id")
+	return data, err
+}
+
+// RouteType is a GTFS route_type, the kind of vehicle serving a route.
+type RouteType int
+
+// The route_type values defined by GTFS which OC Transpo uses.
+const (
+	RouteTypeLightRail RouteType = 0
+	RouteTypeSubway    RouteType = 1
+	RouteTypeRail      RouteType = 2
+	RouteTypeBus       RouteType = 3
+)
+
+// GetRoutesByType returns the routes of a route_type, such as only the buses.
+// OC Transpo doesn't document filtering routes on route_type, so any rows returned
+// with other route_types are also dropped here.
+func (c Connection) GetRoutesByType(ctx context.Context, t RouteType) (*GTFSRoutes, error) {
+	routeType := strconv.Itoa(int(t))
+	routes, err := c.GetGTFSRoutes(ctx, ColumnAndValue("route_type", routeType))
+	if err != nil {
+		return nil, err
+	}
+	ofType := routes.Gtfs[:0]
+	for _, r := range routes.Gtfs {
+		if r.RouteType == routeType {
+			ofType = append(ofType, r)
+		}
+	}
+	routes.Gtfs = ofType
+	return routes, nil
+}
+
+// RouteLongName returns the route_long_name for a route_short_name, such as "1".
+// A short name can have several route_ids, in which case the long name of the
+// lowest route_id is returned, so the result is consistent between calls.
+func (c Connection) RouteLongName(ctx context.Context, shortName string) (string, error) {
+	routes, err := c.GetGTFSRoutes(ctx, ColumnAndValue("route_short_name", shortName))
+	if err != nil {
+		return "", err
+	}
+	var match *GTFSRouteRow
+	for i, r := range routes.Gtfs {
+		if r.RouteShortName != shortName {
+			continue
+		}
+		if match == nil || r.RouteID < match.RouteID {
+			match = &routes.Gtfs[i]
+		}
+	}
+	if match == nil {
+		return "", c.prefixError(fmt.Errorf("no route found with route_short_name %v", shortName))
+	}
+	return match.RouteLongName, nil
+}
+
+// GTFSStops is the GTFS stops table.
+type GTFSStops struct {
+	Query GTFSQuery     `json:"Query"`
+	Gtfs  []GTFSStopRow `json:"Gtfs"`
+}
+
+// GTFSStopRow is a row in the GTFS stops table.
+type GTFSStopRow struct {
+	ID            string `json:"id"`
+	StopID        string `json:"stop_id"`
+	StopCode      string `json:"stop_code"`
+	StopName      string `json:"stop_name"`
+	StopDesc      string `json:"stop_desc"`
+	StopLat       string `json:"stop_lat"`
+	StopLon       string `json:"stop_lon"`
+	ZoneID        string `json:"zone_id"`
+	StopURL       string `json:"stop_url"`
+	LocationType  string `json:"location_type"`
+	ParentStation string `json:"parent_station"`
+	// Extra holds columns the row type doesn't have, when WithExtraFields is set.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// StopCodeInt returns the stop_code as an int, for comparing and sorting stops.
+// An error is returned for stops without a numeric stop_code, such as stations
+// with an alphanumeric code, or no code at all.
+func (r GTFSStopRow) StopCodeInt() (int, error) {
+	code, err := strconv.Atoi(r.StopCode)
+	if err != nil {
+		return 0, fmt.Errorf("stop_code %q isn't numeric", r.StopCode)
+	}
+	return code, nil
+}
+
+// GetGTFSStops returns the GTFS stops table.
+// It requires a stop_id, stop_code, zone_id or id value specified, using ColumnAndValue() or ID() options.
+// A stop_code can match several rows, one for each platform with its own stop_id.
+// PrimaryStop can be used to pick one of them.
+func (c Connection) GetGTFSStops(ctx context.Context, options ...RequestOption) (*GTFSStops, error) {
+	options = append(options, setTable("stops"))
+	u, settings, err := c.setupGTFSURL(options...)
+	if err != nil {
+		return nil, err
+	}
+	v, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	switch {
+	case v.Get("column") == "stop_id", v.Get("column") == "stop_code", v.Get("column") == "zone_id", v.Get("id") != "":
+	default:
+		return nil, c.prefixError(errors.New("a stop_id, stop_code, zone_id or id value must be specified"))
+	}
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
+	if err != nil {
+		return nil, err
+	}
+	data := &GTFSStops{}
+	err = c.decodeGTFS(respBody, data, "id", "stop_id")
+	return data, err
+}
+
+// GetStopsInZone returns the stops in a fare zone, by their zone_id.
+// OC Transpo doesn't document filtering stops on zone_id, so any rows returned
+// from other zones are also dropped here.
+func (c Connection) GetStopsInZone(ctx context.Context, zoneID string) (*GTFSStops, error) {
+	stops, err := c.GetGTFSStops(ctx, ColumnAndValue("zone_id", zoneID))
+	if err != nil {
+		return nil, err
+	}
+	inZone := stops.Gtfs[:0]
+	for _, s := range stops.Gtfs {
+		if s.ZoneID == zoneID {
+			inZone = append(inZone, s)
+		}
+	}
+	stops.Gtfs = inZone
+	return stops, nil
+}
+
+// ToGeoJSON returns the stops as a GeoJSON FeatureCollection, with a Point Feature
+// for each stop, with its stop_id, stop_code and stop_name as properties.
+// Stops with missing or invalid coordinates are left out, and a warning for each
+// is recorded in a "warnings" array on the FeatureCollection.
+func (s *GTFSStops) ToGeoJSON() ([]byte, error) {
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, row := range s.Gtfs {
+		position, err := parseCoordinates(row.StopLat, row.StopLon)
+		if err != nil {
+			collection.Warnings = append(collection.Warnings, fmt.Sprintf("skipped stop_id %v: %v", row.StopID, err))
+			continue
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: position},
+			Properties: map[string]string{
+				"stop_id":   row.StopID,
+				"stop_code": row.StopCode,
+				"stop_name": row.StopName,
+			},
+		})
+	}
+	return json.Marshal(collection)
+}
+
+// PrimaryStop picks a single stop from the rows of a stops table, which can contain
+// several rows when queried by stop_code. Stops without a parent_station are preferred,
+// then the stop with the lowest id. An error is returned if there are no stops, or if
+// the remaining stops have different stop codes, since they aren't the same stop.
+func PrimaryStop(stops *GTFSStops) (GTFSStopRow, error) {
+	var candidates []GTFSStopRow
+	for _, s := range stops.Gtfs {
+		if s.ParentStation == "" {
+			candidates = append(candidates, s)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = stops.Gtfs
+	}
+	if len(candidates) == 0 {
+		return GTFSStopRow{}, errors.New("no stops to choose from")
+	}
+
+	primary := candidates[0]
+	for _, s := range candidates[1:] {
+		if s.StopCode != primary.StopCode {
+			return GTFSStopRow{}, fmt.Errorf("ambiguous stops, stop codes %v and %v", primary.StopCode, s.StopCode)
+		}
+		if lowerID(s.ID, primary.ID) {
+			primary = s
+		}
+	}
+	return primary, nil
+}
+
+// lowerID compares two ids numerically, falling back to comparing them as strings.
+func lowerID(a, b string) bool {
+	ai, aErr := strconv.Atoi(a)
+	bi, bErr := strconv.Atoi(b)
+	if aErr == nil && bErr == nil {
+		return ai < bi
+	}
+	return a < b
+}
+
+// StopsInBounds returns the stops which are within the bounding box.
+// The API can't query stops by location, so the stops must be prefetched,
+// for example with GetGTFSStops, and passed in.
+// Stops without valid coordinates are skipped. If ctx is done before all
+// the stops are checked, only the stops found so far are returned.
+func StopsInBounds(ctx context.Context, minLat, minLon, maxLat, maxLon float64, stops []GTFSStopRow) []GTFSStopRow {
+	var inBounds []GTFSStopRow
+	for _, s := range stops {
+		if ctx.Err() != nil {
+			break
+		}
+		lat, err := strconv.ParseFloat(s.StopLat, 64)
+		if err != nil {
+			continue
+		}
+		lon, err := strconv.ParseFloat(s.StopLon, 64)
+		if err != nil {
+			continue
+		}
+		if lat >= minLat && lat <= maxLat && lon >= minLon && lon <= maxLon {
+			inBounds = append(inBounds, s)
+		}
+	}
+	return inBounds
+}
+
+// GTFSStopTimes is the GTFS stop_times table.
+type GTFSStopTimes struct {
+	Query GTFSQuery         `json:"Query"`
+	Gtfs  []GTFSStopTimeRow `json:"Gtfs"`
+}
+
+// GTFSStopTimeRow is a row in the GTFS stop_times table.
+type GTFSStopTimeRow struct {
+	ID            string `json:"id"`
+	TripID        string `json:"trip_id"`
+	ArrivalTime   string `json:"arrival_time"`
+	DepartureTime string `json:"departure_time"`
+	StopID        string `json:"stop_id"`
+	StopSequence  string `json:"stop_sequence"`
+	PickupType    string `json:"pickup_type"`
+	DropOffType   string `json:"drop_off_type"`
+	// Extra holds columns the row type doesn't have, when WithExtraFields is set.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// ParseGTFSTime parses a GTFS time, such as "17:05:00", as the duration since
+// the start of the service day. Times after midnight are greater than 24 hours,
+// such as "25:10:00" for 1:10 AM the next day.
+func ParseGTFSTime(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid GTFS time %q", s)
+	}
+	var units [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid GTFS time %q", s)
+		}
+		units[i] = n
+	}
+	return time.Duration(units[0])*time.Hour + time.Duration(units[1])*time.Minute + time.Duration(units[2])*time.Second, nil
+}
+
+// Departure returns the departure_time of the row as the duration since the start of the service day.
+func (r GTFSStopTimeRow) Departure() (time.Duration, error) {
+	return ParseGTFSTime(r.DepartureTime)
+}
+
+// DeparturesBetween returns the rows departing at or after start, and before end,
+// both durations since midnight. Departures after midnight, such as "24:30:00",
+// are matched against both their service day time and their clock time, so a
+// window from 0 to 1 hour includes them. If end is before start, the window
+// wraps past midnight. Rows with unparseable departure times are skipped.
+func (st *GTFSStopTimes) DeparturesBetween(start, end time.Duration) []GTFSStopTimeRow {
+	if end < start {
+		end += 24 * time.Hour
+	}
+	var between []GTFSStopTimeRow
+	for _, r := range st.Gtfs {
+		d, err := r.Departure()
+		if err != nil {
+			continue
+		}
+		if (d >= start && d < end) || (d >= 24*time.Hour && d-24*time.Hour >= start && d-24*time.Hour < end) || (d+24*time.Hour >= start && d+24*time.Hour < end) {
+			between = append(between, r)
+		}
+	}
+	return between
+}
+
+// NextAfter returns the row with the earliest departure strictly after t, a duration
+// since midnight, and whether there is one. Departures after midnight, such as "24:20:00",
+// are also matched by their clock time, so with t at 0:10, a departure at "24:20:00"
+// is next, ten minutes later. Rows with unparseable departure times are skipped.
+func (st *GTFSStopTimes) NextAfter(t time.Duration) (GTFSStopTimeRow, bool) {
+	var next GTFSStopTimeRow
+	var nextAt time.Duration
+	found := false
+	for _, r := range st.Gtfs {
+		d, err := r.Departure()
+		if err != nil {
+			continue
+		}
+		candidates := []time.Duration{d}
+		if d >= 24*time.Hour {
+			candidates = append(candidates, d-24*time.Hour)
+		}
+		for _, at := range candidates {
+			if at > t && (!found || at < nextAt) {
+				next, nextAt, found = r, at, true
+			}
+		}
+	}
+	return next, found
+}
+
+// GetGTFSStopTimes returns the GTFS stop_times table.
+// It requires a trip_id, stop_code or id value specified, using ColumnAndValue() or ID() options.
+func (c Connection) GetGTFSStopTimes(ctx context.Context, options ...RequestOption) (*GTFSStopTimes, error) {
+	options = append(options, setTable("stop_times"))
+	u, settings, err := c.setupGTFSURL(options...)
+	if err != nil {
+		return nil, err
+	}
+	v, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	if v.Get("column") != "trip_id" && v.Get("column") != "stop_id" && v.Get("id") == "" {
+		return nil, c.prefixError(errors.New("a trip_id, stop_id or id value must be specified"))
+	}
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
+	if err != nil {
+		return nil, err
+	}
+	data := &GTFSStopTimes{}
+	err = c.decodeGTFS(respBody, data, "id", "trip_id")
+	return data, err
+}
+
+// StreamGTFSStopTimes calls fn for each row of the GTFS stop_times table as it's decoded,
+// rather than holding the whole table in memory. Like GetGTFSStopTimes, it requires a
+// trip_id, stop_id or id value specified, using ColumnAndValue() or ID() options.
+// fn is passed the context, so it can be canceled, or used for downstream work.
+// Streaming stops at the first error returned by fn, or when the context is done.
+func (c Connection) StreamGTFSStopTimes(ctx context.Context, fn func(ctx context.Context, row GTFSStopTimeRow) error, options ...RequestOption) error {
+	options = append(options, setTable("stop_times"))
+	u, settings, err := c.setupGTFSURL(options...)
+	if err != nil {
+		return err
+	}
+	v, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return c.prefixError(err)
+	}
+	if v.Get("column") != "trip_id" && v.Get("column") != "stop_id" && v.Get("id") == "" {
+		return c.prefixError(errors.New("a trip_id, stop_id or id value must be specified"))
+	}
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
+	if err != nil {
+		return err
+	}
+	defer respBody.Close()
+
+	dec := json.NewDecoder(respBody)
+	err = seekGTFSRows(dec)
+	if err != nil {
+		return c.prefixError(err)
+	}
+	for dec.More() {
+		err := ctx.Err()
+		if err != nil {
+			return c.prefixError(err)
+		}
+		row := GTFSStopTimeRow{}
+		err = dec.Decode(&row)
+		if err != nil {
+			return c.prefixError(err)
+		}
+		err = fn(ctx, row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seekGTFSRows advances the decoder to the start of the first row in the Gtfs array.
+func seekGTFSRows(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return errors.New("expected a GTFS object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok != "Gtfs" {
+			var skip json.RawMessage
+			err := dec.Decode(&skip)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		tok, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok != json.Delim('[') {
+			return errors.New("expected a Gtfs array")
+		}
+		return nil
+	}
+	return errors.New("no Gtfs rows in response")
+}
+
+// GTFSTrips is the GTFS trips table.
+type GTFSTrips struct {
+	Query GTFSQuery     `json:"Query"`
+	Gtfs  []GTFSTripRow `json:"Gtfs"`
+}
+
+// GTFSTripRow is a row in the GTFS trips table.
+type GTFSTripRow struct {
+	ID           string `json:"id"`
+	RouteID      string `json:"route_id"`
+	ServiceID    string `json:"service_id"`
+	TripID       string `json:"trip_id"`
+	TripHeadsign string `json:"trip_headsign"`
+	DirectionID  string `json:"direction_id"`
+	BlockID      string `json:"block_id"`
+	// Extra holds columns the row type doesn't have, when WithExtraFields is set.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// GetGTFSTrips returns the GTFS trips table.
+// It requires a route_id, trip_id, service_id or id value specified, using ColumnAndValue() or ID() options.
+func (c Connection) GetGTFSTrips(ctx context.Context, options ...RequestOption) (*GTFSTrips, error) {
+	options = append(options, setTable("trips"))
+	u, settings, err := c.setupGTFSURL(options...)
+	if err != nil {
+		return nil, err
+	}
+	v, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	switch {
+	case v.Get("column") == "route_id", v.Get("column") == "trip_id", v.Get("column") == "service_id", v.Get("id") != "":
+	default:
+		return nil, c.prefixError(errors.New("a route_id, trip_id, service_id or id value must be specified"))
+	}
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
+	if err != nil {
+		return nil, err
+	}
+	data := &GTFSTrips{}
+	err = c.decodeGTFS(respBody, data, "id", "trip_id")
+	return data, err
+}
+
+// GTFSShapes is the GTFS shapes table.
+type GTFSShapes struct {
+	Query GTFSQuery      `json:"Query"`
+	Gtfs  []GTFSShapeRow `json:"Gtfs"`
+}
+
+// GTFSShapeRow is a row in the GTFS shapes table, a point along the path of a shape.
+type GTFSShapeRow struct {
+	ID              string `json:"id"`
+	ShapeID         string `json:"shape_id"`
+	ShapePtLat      string `json:"shape_pt_lat"`
+	ShapePtLon      string `json:"shape_pt_lon"`
+	ShapePtSequence string `json:"shape_pt_sequence"`
+	// Extra holds columns the row type doesn't have, when WithExtraFields is set.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// GetGTFSShapes returns the GTFS shapes table.
+// It requires a shape_id or id value specified, using ColumnAndValue() or ID() options.
+// The shapes table isn't listed in OC Transpo's documentation, so it may not be served.
+func (c Connection) GetGTFSShapes(ctx context.Context, options ...RequestOption) (*GTFSShapes, error) {
+	options = append(options, setTable("shapes"))
+	u, settings, err := c.setupGTFSURL(options...)
+	if err != nil {
+		return nil, err
+	}
+	v, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, c.prefixError(err)
+	}
+	if v.Get("column") != "shape_id" && v.Get("id") == "" {
+		return nil, c.prefixError(errors.New("a shape_id or id value must be specified"))
+	}
+	respBody, err := c.performGTFSRequest(ctx, u, settings)
+	if err != nil {
+		return nil, err
+	}
+	data := &GTFSShapes{}
+	err = c.decodeGTFS(respBody, data, "id", "shape_id")
+	return data, err
+}
+
+// geoJSONFeatureCollection and geoJSONFeature are the GeoJSON objects written by ToGeoJSON.
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+	// Warnings is a foreign member listing rows which were left out.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONGeometry   `json:"geometry"`
+	Properties map[string]string `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// parseCoordinates parses a latitude and longitude as a GeoJSON position, which is longitude first.
+func parseCoordinates(lat, lon string) ([]float64, error) {
+	latValue, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid latitude %q", lat)
+	}
+	lonValue, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid longitude %q", lon)
+	}
+	return []float64{lonValue, latValue}, nil
+}
+
+// ToGeoJSON returns the shapes as a GeoJSON FeatureCollection, with a LineString
+// Feature for each shape_id, in the order the shapes were first returned.
+// The coordinates are ordered by shape_pt_sequence, and the shape_id is a property.
+// A shape with a single point, which isn't a valid LineString, is a Point Feature instead.
+func (s *GTFSShapes) ToGeoJSON() ([]byte, error) {
+	type shapePoint struct {
+		seq      int
+		position []float64
+	}
+	var shapeIDs []string
+	points := make(map[string][]shapePoint)
+	for _, row := range s.Gtfs {
+		seq, err := strconv.Atoi(row.ShapePtSequence)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shape_pt_sequence %q for shape_id %v", row.ShapePtSequence, row.ShapeID)
+		}
+		position, err := parseCoordinates(row.ShapePtLat, row.ShapePtLon)
+		if err != nil {
+			return nil, fmt.Errorf("%v for shape_id %v", err, row.ShapeID)
+		}
+		if _, ok := points[row.ShapeID]; !ok {
+			shapeIDs = append(shapeIDs, row.ShapeID)
+		}
+		points[row.ShapeID] = append(points[row.ShapeID], shapePoint{seq, position})
+	}
+
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, shapeID := range shapeIDs {
+		shape := points[shapeID]
+		sort.SliceStable(shape, func(i, j int) bool {
+			return shape[i].seq < shape[j].seq
+		})
+		geometry := geoJSONGeometry{Type: "Point", Coordinates: shape[0].position}
+		if len(shape) > 1 {
+			coordinates := make([][]float64, len(shape))
+			for i, p := range shape {
+				coordinates[i] = p.position
+			}
+			geometry = geoJSONGeometry{Type: "LineString", Coordinates: coordinates}
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geometry,
+			Properties: map[string]string{"shape_id": shapeID},
+		})
+	}
+	return json.Marshal(collection)
+}
+
+// RouteShortNameForTrip returns the route_short_name, such as "97", of the route
+// a trip_id is on. It looks up the trip's route_id, then the route.
+func (c Connection) RouteShortNameForTrip(ctx context.Context, tripID string) (string, error) {
+	trips, err := c.GetGTFSTrips(ctx, ColumnAndValue("trip_id", tripID), Limit(1))
+	if err != nil {
+		return "", err
+	}
+	if len(trips.Gtfs) == 0 {
+		return "", c.prefixError(fmt.Errorf("no trip found with trip_id %v", tripID))
+	}
+	routes, err := c.GetGTFSRoutes(ctx, ColumnAndValue("route_id", trips.Gtfs[0].RouteID), Limit(1))
+	if err != nil {
+		return "", err
+	}
+	if len(routes.Gtfs) == 0 {
+		return "", c.prefixError(fmt.Errorf("no route found with route_id %v", trips.Gtfs[0].RouteID))
+	}
+	return routes.Gtfs[0].RouteShortName, nil
+}
+
+// ErrInvalidTripID is returned by ParseTripID when a trip_id isn't in the expected format.
+var ErrInvalidTripID = errors.New("trip_id not in the expected format")
+
+// TripIDParts holds the components of an OC Transpo trip_id,
+// such as "27212870-CADA13-CADA13-Sunday-71".
+type TripIDParts struct {
+	// Sequence is the leading trip number, such as 27212870.
+	Sequence int
+	// BookingCodes are the service booking codes, such as ["CADA13", "CADA13"].
+	BookingCodes []string
+	// Day is the day type, such as "Sunday", "Saturday" or "Weekday".
+	Day string
+	// Suffix is the trailing number, such as "71".
+	Suffix string
+}
+
+// Weekday returns the day of the week of the trip's Day.
+// It returns false for days which aren't a single day of the week, such as "Weekday".
+func (p TripIDParts) Weekday() (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(p.Day, d.String()) {
+			return d, true
+		}
+	}
+	return time.Sunday, false
+}
+
+// ParseTripID splits a trip_id into its components. The format isn't documented,
+// and is inferred from the trip_ids OC Transpo publishes. It has changed between
+// GTFS releases before, so the result shouldn't be relied on for anything but display
+// or heuristics, and ErrInvalidTripID should be expected for future trip_ids.
+func ParseTripID(id string) (TripIDParts, error) {
+	parts := strings.Split(id, "-")
+	if len(parts) < 4 {
+		return TripIDParts{}, fmt.Errorf("%w: %q", ErrInvalidTripID, id)
+	}
+	seq, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return TripIDParts{}, fmt.Errorf("%w: %q", ErrInvalidTripID, id)
+	}
+	n := len(parts)
+	for _, p := range parts[1:] {
+		if p == "" {
+			return TripIDParts{}, fmt.Errorf("%w: %q", ErrInvalidTripID, id)
+		}
+	}
+	return TripIDParts{
+		Sequence:     seq,
+		BookingCodes: parts[1 : n-2],
+		Day:          parts[n-2],
+		Suffix:       parts[n-1],
+	}, nil
+}
+
+// TripsBetweenStops returns the trip_ids of trips which stop at fromStopID,
+// and later at toStopID, in the order their stop_times were returned for fromStopID.
+// It queries the stop_times of both stops, comparing their stop_sequence.
+func (c Connection) TripsBetweenStops(ctx context.Context, fromStopID, toStopID string) ([]string, error) {
+	from, err := c.GetGTFSStopTimes(ctx, ColumnAndValue("stop_id", fromStopID))
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.GetGTFSStopTimes(ctx, ColumnAndValue("stop_id", toStopID))
+	if err != nil {
+		return nil, err
+	}
+
+	// The last visit to the destination, in case a trip loops past it more than once.
+	last := make(map[string]int)
+	for _, st := range to.Gtfs {
+		seq, err := strconv.Atoi(st.StopSequence)
+		if err != nil {
+			return nil, c.prefixError(fmt.Errorf("invalid stop_sequence %q for trip_id %v", st.StopSequence, st.TripID))
+		}
+		if prev, ok := last[st.TripID]; !ok || seq > prev {
+			last[st.TripID] = seq
+		}
+	}
+
+	var tripIDs []string
+	seen := make(map[string]bool)
+	for _, st := range from.Gtfs {
+		seq, err := strconv.Atoi(st.StopSequence)
+		if err != nil {
+			return nil, c.prefixError(fmt.Errorf("invalid stop_sequence %q for trip_id %v", st.StopSequence, st.TripID))
+		}
+		toSeq, ok := last[st.TripID]
+		if !ok || seq >= toSeq || seen[st.TripID] {
+			continue
+		}
+		seen[st.TripID] = true
+		tripIDs = append(tripIDs, st.TripID)
+	}
+	return tripIDs, nil
+}
+
+// StopsForTrip returns the stops a trip visits, in stop_sequence order, such as for
+// drawing the trip on a map. It fetches the trip's stop_times, then the details of
+// each stop concurrently. Stops visited more than once, such as by looping trips,
+// are only fetched once, but are returned for each visit.
+// With WithPartialOnTimeout, the stops fetched before the context's deadline are
+// returned along with the error, leaving out the visits to the others.
+func (c Connection) StopsForTrip(ctx context.Context, tripID string) ([]GTFSStopRow, error) {
+	times, err := c.GetGTFSStopTimes(ctx, ColumnAndValue("trip_id", tripID))
+	if err != nil {
+		return nil, err
+	}
+	type visit struct {
+		seq int
+		st  GTFSStopTimeRow
+	}
+	visits := make([]visit, len(times.Gtfs))
+	for i, st := range times.Gtfs {
+		seq, err := strconv.Atoi(st.StopSequence)
+		if err != nil {
+			return nil, c.prefixError(fmt.Errorf("invalid stop_sequence %q for trip_id %v", st.StopSequence, st.TripID))
+		}
+		visits[i] = visit{seq: seq, st: st}
+	}
+	sort.SliceStable(visits, func(i, j int) bool {
+		return visits[i].seq < visits[j].seq
+	})
+	stopTimes := make([]GTFSStopTimeRow, len(visits))
+	for i, v := range visits {
+		stopTimes[i] = v.st
+	}
+
+	var stopIDs []string
+	stops := make(map[string]*GTFSStopRow)
+	for _, st := range stopTimes {
+		if _, ok := stops[st.StopID]; !ok {
+			stops[st.StopID] = nil
+			stopIDs = append(stopIDs, st.StopID)
+		}
+	}
+	results := make([]*GTFSStops, len(stopIDs))
+	fns := make([]func(context.Context) error, len(stopIDs))
+	for i, stopID := range stopIDs {
+		i, stopID := i, stopID
+		fns[i] = func(ctx context.Context) (err error) {
+			results[i], err = c.GetGTFSStops(ctx, ColumnAndValue("stop_id", stopID), Limit(1))
+			return err
+		}
+	}
+	errs, failed := c.fanOut(ctx, fns...)
+	if failed != nil {
+		return nil, failed
+	}
+	missing := 0
+	for i, stopID := range stopIDs {
+		if c.timedOut(errs[i]) {
+			missing++
+			continue
+		}
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if len(results[i].Gtfs) == 0 {
+			return nil, c.prefixError(fmt.Errorf("no stop found with stop_id %v", stopID))
+		}
+		stops[stopID] = &results[i].Gtfs[0]
+	}
+
+	ordered := make([]GTFSStopRow, 0, len(stopTimes))
+	for _, st := range stopTimes {
+		if stops[st.StopID] != nil {
+			ordered = append(ordered, *stops[st.StopID])
+		}
+	}
+	if missing > 0 {
+		return ordered, c.partialError(missing, len(stopIDs))
+	}
+	return ordered, nil
+}
+
+// ServiceSpanForStop returns the first and last scheduled departures at a stop on the date,
+// as durations since the start of the service day. Only the stop_times of trips with a
+// service_id active on the date, according to ActiveServiceIDs, are included.
+// This makes a request for the trips of each active service_id, as well as for the
+// calendar, calendar_dates and the stop's stop_times.
+func (c Connection) ServiceSpanForStop(ctx context.Context, stopID string, date time.Time) (first, last time.Duration, err error) {
+	serviceIDs, err := c.ActiveServiceIDs(ctx, date)
+	if err != nil {
+		return 0, 0, err
+	}
+	active := make(map[string]bool)
+	for _, id := range serviceIDs {
+		trips, err := c.GetGTFSTrips(ctx, ColumnAndValue("service_id", id), Fields("id", "trip_id", "service_id"))
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, t := range trips.Gtfs {
+			active[t.TripID] = true
+		}
+	}
+	if len(active) == 0 {
+		return 0, 0, c.prefixError(fmt.Errorf("no trips run on %v", date.Format(gtfsDateLayout)))
+	}
+
+	stopTimes, err := c.GetGTFSStopTimes(ctx, ColumnAndValue("stop_id", stopID))
+	if err != nil {
+		return 0, 0, err
+	}
+	found := false
+	for _, st := range stopTimes.Gtfs {
+		if !active[st.TripID] {
+			continue
+		}
+		departure, err := st.Departure()
+		if err != nil {
+			return 0, 0, c.prefixError(err)
+		}
+		if !found || departure < first {
+			first = departure
+		}
+		if !found || departure > last {
+			last = departure
+		}
+		found = true
+	}
+	if !found {
+		return 0, 0, c.prefixError(fmt.Errorf("no departures from stop_id %v on %v", stopID, date.Format(gtfsDateLayout)))
+	}
+	return first, last, nil
+}
+
+// ScheduledMinutesAway returns how many minutes after the route direction's
+// RequestProcessingTime the trip is scheduled to depart from the stop, according to GTFS,
+// for use with DelayVsSchedule. The GTFS trip is matched on its start time: the stop's
+// departures from the trip's TripStartTime until an hour after it's expected are checked
+// in order, for the first trip whose first departure is at TripStartTime.
+// This makes a request for the stop's stop_times, and one for each trip checked.
+func (c Connection) ScheduledMinutesAway(ctx context.Context, stopID string, rd RouteDirection, t Trip) (int, error) {
+	start, err := ParseGTFSTime(t.TripStartTime + ":00")
+	if err != nil {
+		return 0, c.prefixError(err)
+	}
+	h, m, sec := rd.RequestProcessingTime.Clock()
+	now := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	// A trip which started late in the previous service day, with the request after midnight.
+	if start-now > 12*time.Hour {
+		now += 24 * time.Hour
+	}
+	latest := now + time.Duration(t.AdjustedScheduleTime)*time.Minute + time.Hour
+
+	stopTimes, err := c.GetGTFSStopTimes(ctx, ColumnAndValue("stop_id", stopID))
+	if err != nil {
+		return 0, err
+	}
+	var candidates []GTFSStopTimeRow
+	for _, st := range stopTimes.Gtfs {
+		departure, err := st.Departure()
+		if err == nil && departure >= start && departure <= latest {
+			candidates = append(candidates, st)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		di, _ := candidates[i].Departure()
+		dj, _ := candidates[j].Departure()
+		return di < dj
+	})
+
+	for _, candidate := range candidates {
+		tripStopTimes, err := c.GetGTFSStopTimes(ctx, ColumnAndValue("trip_id", candidate.TripID))
+		if err != nil {
+			return 0, err
+		}
+		first, ok := firstStopTime(tripStopTimes.Gtfs)
+		if !ok {
+			continue
+		}
+		firstDeparture, err := first.Departure()
+		if err != nil || firstDeparture != start {
+			continue
+		}
+		departure, _ := candidate.Departure()
+		return int((departure - now) / time.Minute), nil
+	}
+	return 0, c.prefixError(fmt.Errorf("no scheduled trip starting at %v found for stop_id %v", t.TripStartTime, stopID))
+}
+
+// firstStopTime returns the row with the lowest stop_sequence.
+func firstStopTime(rows []GTFSStopTimeRow) (GTFSStopTimeRow, bool) {
+	var first GTFSStopTimeRow
+	firstSeq := -1
+	for _, r := range rows {
+		seq, err := strconv.Atoi(r.StopSequence)
+		if err != nil {
+			continue
+		}
+		if firstSeq == -1 || seq < firstSeq {
+			first, firstSeq = r, seq
+		}
+	}
+	return first, firstSeq != -1
 }
@@ -1,11 +1,18 @@
 package gooctranspoapi
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGTFSAgency(t *testing.T) {
@@ -235,3 +242,1660 @@ func TestGTFSTrips(t *testing.T) {
 		t.Fatal("Unexpected BlockID in returned GTFSTrips")
 	}
 }
+
+func TestGTFSQueryBuilder(t *testing.T) {
+	q := &GTFSQueryBuilder{}
+	q.Table("trips").Where("route_id", "1-146").OrderBy("id").Direction("desc").Limit(5)
+
+	v, err := q.Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "column=route_id&direction=desc&limit=5&orderBy=id&table=trips&value=1-146"
+	if v.Encode() != expected {
+		t.Fatalf("Unexpected query string from GTFSQueryBuilder: %v", v.Encode())
+	}
+
+	_, err = (&GTFSQueryBuilder{}).Direction("sideways").Values()
+	if err == nil {
+		t.Fatal("Expected error from GTFSQueryBuilder with invalid direction")
+	}
+}
+
+func TestGetGTFSTable(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"routes","direction":"ASC",
+	                            "column":"route_short_name","value":"1","format":"json"},
+	                   "Gtfs":[{"id":"1","route_id":"1-146",
+	                            "route_short_name":"1","route_long_name":"",
+	                            "route_desc":"","route_type":"3"}]}`
+
+	var query url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	q := &GTFSQueryBuilder{}
+	q.Table("routes").Where("route_short_name", "1").Limit(1)
+
+	routes := &GTFSRoutes{}
+	err := c.GetGTFSTable(context.TODO(), q, routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if query.Get("table") != "routes" || query.Get("column") != "route_short_name" || query.Get("limit") != "1" {
+		t.Fatalf("Unexpected query sent by GetGTFSTable: %v", query.Encode())
+	}
+	if routes.Gtfs[0].RouteID != "1-146" {
+		t.Fatal("Unexpected RouteID in GTFSRoutes returned by GetGTFSTable")
+	}
+
+	err = c.GetGTFSTable(context.TODO(), &GTFSQueryBuilder{}, routes)
+	if err == nil {
+		t.Fatal("Expected error from GetGTFSTable without a table")
+	}
+}
+
+func TestStopsInBounds(t *testing.T) {
+	stops := []GTFSStopRow{
+		{StopID: "AA010", StopCode: "3020", StopLat: "45.420734", StopLon: "-75.680776"},
+		{StopID: "AB020", StopCode: "7659", StopLat: "45.399911", StopLon: "-75.688965"},
+		{StopID: "AC030", StopCode: "3051", StopLat: "45.347431", StopLon: "-75.807323"},
+		{StopID: "AD040", StopCode: "1234", StopLat: "", StopLon: ""},
+	}
+
+	inBounds := StopsInBounds(context.TODO(), 45.39, -75.70, 45.43, -75.67, stops)
+	if len(inBounds) != 2 {
+		t.Fatalf("Unexpected number of stops returned by StopsInBounds: %v", len(inBounds))
+	}
+	if inBounds[0].StopID != "AA010" || inBounds[1].StopID != "AB020" {
+		t.Fatal("Unexpected stops returned by StopsInBounds")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	inBounds = StopsInBounds(ctx, 45.39, -75.70, 45.43, -75.67, stops)
+	if len(inBounds) != 0 {
+		t.Fatal("Expected no stops from StopsInBounds with a canceled context")
+	}
+}
+
+func TestGTFSParam(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"agency","direction":"ASC","format":"json"},
+	                   "Gtfs":[]}`
+
+	var query url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetGTFSAgency(context.TODO(), Param("custom", "value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Get("custom") != "value" {
+		t.Fatal("Expected custom param in GTFS request")
+	}
+
+	_, err = c.GetGTFSAgency(context.TODO(), Param("appID", "otherID"))
+	if err == nil {
+		t.Fatal("Expected error from Param overwriting appID")
+	}
+	_, err = c.GetGTFSAgency(context.TODO(), Param("format", "xml"))
+	if err == nil {
+		t.Fatal("Expected error from Param overwriting format")
+	}
+	_, err = c.GetGTFSAgency(context.TODO(), Param("table", "stops"))
+	if err == nil {
+		t.Fatal("Expected error from Param overwriting table")
+	}
+}
+
+func TestGTFSCalendarDateRowException(t *testing.T) {
+	exception, err := GTFSCalendarDateRow{ExceptionType: "1"}.Exception()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exception != ServiceAdded {
+		t.Fatal("Expected ServiceAdded for exception_type 1")
+	}
+
+	exception, err = GTFSCalendarDateRow{ExceptionType: "2"}.Exception()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exception != ServiceRemoved {
+		t.Fatal("Expected ServiceRemoved for exception_type 2")
+	}
+
+	_, err = GTFSCalendarDateRow{ExceptionType: "3"}.Exception()
+	if err == nil {
+		t.Fatal("Expected error for unknown exception_type")
+	}
+}
+
+func TestActiveServiceIDs(t *testing.T) {
+	rawCalendarJSONString := `{"Query":{"table":"calendar","direction":"ASC","format":"json"},
+	                           "Gtfs":[{"id":"1","service_id":"Weekday",
+	                                    "monday":"1","tuesday":"1","wednesday":"1",
+	                                    "thursday":"1","friday":"1","saturday":"0",
+	                                    "sunday":"0","start_date":"20180801",
+	                                    "end_date":"20180930"},
+	                                   {"id":"2","service_id":"Saturday",
+	                                    "monday":"0","tuesday":"0","wednesday":"0",
+	                                    "thursday":"0","friday":"0","saturday":"1",
+	                                    "sunday":"0","start_date":"20180801",
+	                                    "end_date":"20180930"},
+	                                   {"id":"3","service_id":"OldWeekday",
+	                                    "monday":"1","tuesday":"1","wednesday":"1",
+	                                    "thursday":"1","friday":"1","saturday":"0",
+	                                    "sunday":"0","start_date":"20180101",
+	                                    "end_date":"20180731"}]}`
+	rawCalendarDatesJSONString := `{"Query":{"table":"calendar_dates","direction":"ASC",
+	                                         "column":"date","value":"20180903","format":"json"},
+	                                "Gtfs":[{"id":"1","service_id":"Weekday",
+	                                         "date":"20180903","exception_type":"2"},
+	                                        {"id":"2","service_id":"Sunday",
+	                                         "date":"20180903","exception_type":"1"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("table") == "calendar_dates" {
+			fmt.Fprint(w, rawCalendarDatesJSONString)
+			return
+		}
+		fmt.Fprint(w, rawCalendarJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	// Labour Day, a Monday holiday.
+	serviceIDs, err := c.ActiveServiceIDs(context.TODO(), time.Date(2018, time.September, 3, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serviceIDs) != 1 || serviceIDs[0] != "Sunday" {
+		t.Fatalf("Unexpected service IDs returned by ActiveServiceIDs: %v", serviceIDs)
+	}
+}
+
+func TestPing(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"agency","direction":"ASC","limit":"1","format":"json"},
+	                   "Gtfs":[{"id":"1","agency_name":"Test Agency"}]}`
+
+	var accept string
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		switch r.URL.Query().Get("apiKey") {
+		case "badKey":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "malformedKey":
+			fmt.Fprint(w, "<html>Service Unavailable</html>")
+		default:
+			fmt.Fprint(w, rawJSONString)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "goodKey")
+	c.cAPIURLPrefix = ts.URL + "/"
+	err := c.Ping(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accept != "application/json" {
+		t.Fatal("Unexpected Accept header sent by Ping")
+	}
+
+	c = NewConnection("", "badKey")
+	c.cAPIURLPrefix = ts.URL + "/"
+	err = c.Ping(context.TODO())
+	if !errors.Is(err, ErrBadCredentials) {
+		t.Fatalf("Expected ErrBadCredentials from Ping, got %v", err)
+	}
+
+	c = NewConnection("", "malformedKey")
+	c.cAPIURLPrefix = ts.URL + "/"
+	err = c.Ping(context.TODO())
+	if !errors.Is(err, ErrMalformedResponse) {
+		t.Fatalf("Expected ErrMalformedResponse from Ping, got %v", err)
+	}
+
+	closed := httptest.NewServer(http.HandlerFunc(rawHandler))
+	closed.Close()
+	c = NewConnection("", "goodKey")
+	c.cAPIURLPrefix = closed.URL + "/"
+	err = c.Ping(context.TODO())
+	if !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Expected ErrUnreachable from Ping, got %v", err)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"routes","direction":"ASC","format":"json"},
+	                   "Gtfs":[{"id":"1","route_id":"1-146",
+	                            "route_short_name":"1","route_long_name":"",
+	                            "route_desc":"","route_type":"3"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	routes, err := c.GetGTFSRoutes(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteCSV(&buf, routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "id,route_id,route_short_name,route_long_name,route_desc,route_type\n1,1-146,1,,,3\n"
+	if buf.String() != expected {
+		t.Fatalf("Unexpected CSV written by WriteCSV: %q", buf.String())
+	}
+
+	err = WriteCSV(&buf, "not a table")
+	if err == nil {
+		t.Fatal("Expected error from WriteCSV with a non GTFS table")
+	}
+}
+
+func TestRouteLongName(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"routes","direction":"ASC",
+	                            "column":"route_short_name","value":"1","format":"json"},
+	                   "Gtfs":[{"id":"2","route_id":"1-147",
+	                            "route_short_name":"1","route_long_name":"Ottawa-Rockcliffe / South Keys",
+	                            "route_desc":"","route_type":"3"},
+	                           {"id":"1","route_id":"1-146",
+	                            "route_short_name":"1","route_long_name":"Ottawa-Rockcliffe",
+	                            "route_desc":"","route_type":"3"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("value") != "1" {
+			fmt.Fprint(w, `{"Query":{"table":"routes"},"Gtfs":[]}`)
+			return
+		}
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	longName, err := c.RouteLongName(context.TODO(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if longName != "Ottawa-Rockcliffe" {
+		t.Fatalf("Unexpected long name returned by RouteLongName: %v", longName)
+	}
+
+	_, err = c.RouteLongName(context.TODO(), "999")
+	if err == nil {
+		t.Fatal("Expected error from RouteLongName for an unknown route")
+	}
+}
+
+func TestWithGTFSValidation(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"routes","direction":"ASC","format":"json"},
+	                   "Gtfs":[{"id":"1","routeId":"1-146",
+	                            "route_short_name":"1","route_type":"3"},
+	                           {"id":"2","routeId":"2-146",
+	                            "route_short_name":"2","route_type":"3"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetGTFSRoutes(context.TODO())
+	if err != nil {
+		t.Fatal("Unexpected error without validation", err)
+	}
+
+	c = NewConnection("", "", WithGTFSValidation())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err = c.GetGTFSRoutes(context.TODO())
+	if !errors.Is(err, ErrSchemaDrift) {
+		t.Fatalf("Expected ErrSchemaDrift from renamed route_id, got %v", err)
+	}
+}
+
+func TestPrimaryStop(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"stops","direction":"ASC",
+	                            "column":"stop_code","value":"3020","format":"json"},
+	                   "Gtfs":[{"id":"12","stop_id":"AA010","stop_code":"3020",
+	                            "stop_name":"LAURIER 1A","stop_lat":"45.420734",
+	                            "stop_lon":"-75.680776","parent_station":""},
+	                           {"id":"9","stop_id":"AA020","stop_code":"3020",
+	                            "stop_name":"LAURIER 2A","stop_lat":"45.420850",
+	                            "stop_lon":"-75.680850","parent_station":""}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	stops, err := c.GetGTFSStops(context.TODO(), ColumnAndValue("stop_code", "3020"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary, err := PrimaryStop(stops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if primary.StopID != "AA020" {
+		t.Fatalf("Unexpected stop returned by PrimaryStop: %v", primary.StopID)
+	}
+
+	stops.Gtfs[1].ParentStation = "AA000"
+	primary, err = PrimaryStop(stops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if primary.StopID != "AA010" {
+		t.Fatalf("Unexpected stop returned by PrimaryStop with a parent_station: %v", primary.StopID)
+	}
+
+	stops.Gtfs[1].ParentStation = ""
+	stops.Gtfs[1].StopCode = "3021"
+	_, err = PrimaryStop(stops)
+	if err == nil {
+		t.Fatal("Expected error from PrimaryStop with different stop codes")
+	}
+}
+
+func TestPrefetchStatic(t *testing.T) {
+	rawJSONStrings := map[string]string{
+		"agency": `{"Query":{"table":"agency","direction":"ASC","format":"json"},
+		            "Gtfs":[{"id":"1","agency_name":"Test Agency",
+		                     "agency_timezone":"America/Toronto"}]}`,
+		"calendar": `{"Query":{"table":"calendar","direction":"ASC","format":"json"},
+		              "Gtfs":[{"id":"1","service_id":"JUN26-JUNDA13-Weekday-01"}]}`,
+		"calendar_dates": `{"Query":{"table":"calendar_dates","direction":"ASC","format":"json"},
+		                    "Gtfs":[{"id":"1","service_id":"JUN26-JUNDA13-Weekday-01",
+		                             "date":"20130701","exception_type":"2"}]}`,
+		"routes": `{"Query":{"table":"routes","direction":"ASC","format":"json"},
+		            "Gtfs":[{"id":"1","route_id":"1-146","route_short_name":"1"}]}`,
+	}
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONStrings[r.URL.Query().Get("table")])
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnectionWithRateLimit("", "", 100, 2)
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	feed, err := c.PrefetchStatic(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Agency.Gtfs[0].AgencyName != "Test Agency" {
+		t.Fatal("Unexpected Agency in StaticFeed")
+	}
+	if feed.Calendar.Gtfs[0].ServiceID != "JUN26-JUNDA13-Weekday-01" {
+		t.Fatal("Unexpected Calendar in StaticFeed")
+	}
+	if feed.CalendarDates.Gtfs[0].Date != "20130701" {
+		t.Fatal("Unexpected CalendarDates in StaticFeed")
+	}
+	if feed.Routes.Gtfs[0].RouteID != "1-146" {
+		t.Fatal("Unexpected Routes in StaticFeed")
+	}
+}
+
+func TestGTFSQueryCacheKey(t *testing.T) {
+	first, err := (&GTFSQueryBuilder{}).Table("trips").Where("route_id", "1-146").Direction("asc").Limit(5).Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := (&GTFSQueryBuilder{}).Limit(5).Direction("asc").Where("route_id", "1-146").Table("trips").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.CacheKey() != second.CacheKey() {
+		t.Fatalf("Expected the same CacheKey for identical queries: %v %v", first.CacheKey(), second.CacheKey())
+	}
+
+	third, err := (&GTFSQueryBuilder{}).Table("trips").Where("route_id", "1-147").Direction("asc").Limit(5).Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.CacheKey() == third.CacheKey() {
+		t.Fatal("Expected different CacheKeys for different queries")
+	}
+
+	rawJSONString := `{"Query":{"table":"trips","direction":"ASC",
+	                            "column":"route_id","value":"1-146","limit":5,
+	                            "format":"json"},
+	                   "Gtfs":[]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("secretID", "secretKey")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	trips, err := c.GetGTFSTrips(context.TODO(), ColumnAndValue("route_id", "1-146"), Direction("asc"), Limit(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trips.Query.CacheKey() != first.CacheKey() {
+		t.Fatalf("Expected echoed query to have the same CacheKey: %v", trips.Query.CacheKey())
+	}
+	if strings.Contains(first.CacheKey(), "secret") {
+		t.Fatal("Unexpected credentials in CacheKey")
+	}
+}
+
+func TestGTFSLimit(t *testing.T) {
+	tests := map[string]GTFSLimit{
+		`{"limit":5}`:   "5",
+		`{"limit":"5"}`: "5",
+		`{"limit":""}`:  "",
+		`{}`:            "",
+	}
+	for raw, want := range tests {
+		var q GTFSQuery
+		err := json.Unmarshal([]byte(raw), &q)
+		if err != nil {
+			t.Fatalf("Unexpected error decoding %v: %v", raw, err)
+		}
+		if q.Limit != want {
+			t.Fatalf("Unexpected Limit decoding %v: %q", raw, q.Limit)
+		}
+	}
+
+	var q GTFSQuery
+	err := json.Unmarshal([]byte(`{"limit":true}`), &q)
+	if err == nil {
+		t.Fatal("Expected error decoding a limit which isn't a number or a string")
+	}
+
+	rawJSONString := `{"Query":{"table":"routes","direction":"ASC","limit":"","format":"json"},
+	                   "Gtfs":[]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	routes, err := c.GetGTFSRoutes(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes.Query.Limit != "" || strings.Contains(routes.Query.CacheKey(), "limit") {
+		t.Fatal("Unexpected Limit in returned GTFSRoutes")
+	}
+}
+
+func TestWithExtraFields(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"routes","direction":"ASC","format":"json"},
+	                   "Gtfs":[{"id":"1","route_id":"1-146",
+	                            "route_short_name":"1","route_long_name":"",
+	                            "route_desc":"","route_type":"3",
+	                            "route_color":"FF0000"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	routes, err := c.GetGTFSRoutes(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes.Gtfs[0].Extra != nil {
+		t.Fatal("Unexpected Extra fields without WithExtraFields")
+	}
+
+	c = NewConnection("", "", WithExtraFields())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	routes, err = c.GetGTFSRoutes(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes.Gtfs[0].RouteID != "1-146" {
+		t.Fatal("Unexpected RouteID with WithExtraFields")
+	}
+	if len(routes.Gtfs[0].Extra) != 1 || string(routes.Gtfs[0].Extra["route_color"]) != `"FF0000"` {
+		t.Fatalf("Unexpected Extra fields with WithExtraFields: %v", routes.Gtfs[0].Extra)
+	}
+}
+
+func TestDeparturesBetween(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"stop_times","direction":"ASC",
+	                            "column":"stop_id","value":"AA010","format":"json"},
+	                   "Gtfs":[{"id":"1","trip_id":"1","arrival_time":"16:59:00",
+	                            "departure_time":"16:59:00","stop_id":"AA010","stop_sequence":"3"},
+	                           {"id":"2","trip_id":"2","arrival_time":"17:00:00",
+	                            "departure_time":"17:00:00","stop_id":"AA010","stop_sequence":"3"},
+	                           {"id":"3","trip_id":"3","arrival_time":"17:59:00",
+	                            "departure_time":"17:59:00","stop_id":"AA010","stop_sequence":"3"},
+	                           {"id":"4","trip_id":"4","arrival_time":"18:00:00",
+	                            "departure_time":"18:00:00","stop_id":"AA010","stop_sequence":"3"},
+	                           {"id":"5","trip_id":"5","arrival_time":"24:20:00",
+	                            "departure_time":"24:20:00","stop_id":"AA010","stop_sequence":"3"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	times, err := c.GetGTFSStopTimes(context.TODO(), ColumnAndValue("stop_id", "AA010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	between := times.DeparturesBetween(17*time.Hour, 18*time.Hour)
+	if len(between) != 2 || between[0].TripID != "2" || between[1].TripID != "3" {
+		t.Fatalf("Unexpected departures between 17:00 and 18:00: %v", between)
+	}
+
+	between = times.DeparturesBetween(0, time.Hour)
+	if len(between) != 1 || between[0].TripID != "5" {
+		t.Fatalf("Unexpected departures between 00:00 and 01:00: %v", between)
+	}
+
+	between = times.DeparturesBetween(23*time.Hour, 30*time.Minute)
+	if len(between) != 1 || between[0].TripID != "5" {
+		t.Fatalf("Unexpected departures between 23:00 and 00:30: %v", between)
+	}
+}
+
+func TestRouteShortNameForTrip(t *testing.T) {
+	rawTripsJSONString := `{"Query":{"table":"trips","direction":"ASC",
+	                                 "column":"trip_id","value":"27210104-CADA13-CADA13-Sunday-71",
+	                                 "format":"json"},
+	                        "Gtfs":[{"id":"1","route_id":"97-147",
+	                                 "service_id":"CADA13-CADA13-Sunday-71",
+	                                 "trip_id":"27210104-CADA13-CADA13-Sunday-71",
+	                                 "trip_headsign":"Airport","block_id":"3406628"}]}`
+	rawRoutesJSONString := `{"Query":{"table":"routes","direction":"ASC",
+	                                  "column":"route_id","value":"97-147","format":"json"},
+	                         "Gtfs":[{"id":"1","route_id":"97-147",
+	                                  "route_short_name":"97","route_long_name":"",
+	                                  "route_desc":"","route_type":"3"}]}`
+
+	var requests []url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Query())
+		if r.URL.Query().Get("table") == "trips" {
+			fmt.Fprint(w, rawTripsJSONString)
+			return
+		}
+		fmt.Fprint(w, rawRoutesJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	shortName, err := c.RouteShortNameForTrip(context.TODO(), "27210104-CADA13-CADA13-Sunday-71")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shortName != "97" {
+		t.Fatalf("Unexpected route short name from RouteShortNameForTrip: %v", shortName)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("Unexpected number of requests from RouteShortNameForTrip: %v", len(requests))
+	}
+	if requests[0].Get("column") != "trip_id" || requests[1].Get("value") != "97-147" || requests[1].Get("limit") != "1" {
+		t.Fatal("Unexpected requests from RouteShortNameForTrip")
+	}
+}
+
+func TestColumnRange(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"stop_times","direction":"ASC",
+	                            "column":"stop_id","value":"AA010","format":"json"},
+	                   "Gtfs":[{"id":"1","trip_id":"1","arrival_time":"9:59:00",
+	                            "departure_time":"9:59:00","stop_id":"AA010","stop_sequence":"3"},
+	                           {"id":"2","trip_id":"2","arrival_time":"10:00:00",
+	                            "departure_time":"10:00:00","stop_id":"AA010","stop_sequence":"3"},
+	                           {"id":"3","trip_id":"3","arrival_time":"10:30:00",
+	                            "departure_time":"10:30:00","stop_id":"AA010","stop_sequence":"3"},
+	                           {"id":"4","trip_id":"4","arrival_time":"11:00:01",
+	                            "departure_time":"11:00:01","stop_id":"AA010","stop_sequence":"3"}]}`
+
+	var query url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	times, err := c.GetGTFSStopTimes(context.TODO(), ColumnAndValue("stop_id", "AA010"), ColumnRange("arrival_time", "10:00:00", "11:00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range query {
+		switch key {
+		case "appID", "apiKey", "format", "table", "column", "value":
+		default:
+			t.Fatalf("Unexpected range parameter sent to the API: %v", key)
+		}
+	}
+	if query.Get("column") != "stop_id" {
+		t.Fatal("Unexpected column sent to the API")
+	}
+	if times.Query.Table != "stop_times" {
+		t.Fatal("Unexpected Query in range filtered GTFSStopTimes")
+	}
+	if len(times.Gtfs) != 2 || times.Gtfs[0].TripID != "2" || times.Gtfs[1].TripID != "3" {
+		t.Fatalf("Unexpected rows in range filtered GTFSStopTimes: %v", times.Gtfs)
+	}
+
+	v := url.Values{}
+	settings, err := applyOptions(v, []RequestOption{ColumnRange("arrival_time", "10:00:00", "11:00:00")})
+	if err != nil || settings.columnRange == nil || len(v) != 0 {
+		t.Fatal("Expected ColumnRange to set the request's settings, not its parameters")
+	}
+}
+
+func TestStreamGTFSStopTimes(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"stop_times","direction":"ASC",
+	                            "column":"stop_id","value":"AA010","format":"json"},
+	                   "Gtfs":[{"id":"1","trip_id":"1","arrival_time":"10:00:00",
+	                            "departure_time":"10:00:00","stop_id":"AA010","stop_sequence":"3"},
+	                           {"id":"2","trip_id":"2","arrival_time":"10:30:00",
+	                            "departure_time":"10:30:00","stop_id":"AA010","stop_sequence":"3"},
+	                           {"id":"3","trip_id":"3","arrival_time":"11:00:00",
+	                            "departure_time":"11:00:00","stop_id":"AA010","stop_sequence":"3"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	var tripIDs []string
+	err := c.StreamGTFSStopTimes(context.TODO(), func(ctx context.Context, row GTFSStopTimeRow) error {
+		tripIDs = append(tripIDs, row.TripID)
+		return nil
+	}, ColumnAndValue("stop_id", "AA010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tripIDs) != 3 || tripIDs[2] != "3" {
+		t.Fatalf("Unexpected rows from StreamGTFSStopTimes: %v", tripIDs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	err = c.StreamGTFSStopTimes(ctx, func(ctx context.Context, row GTFSStopTimeRow) error {
+		calls++
+		cancel()
+		select {
+		case <-ctx.Done():
+		default:
+			t.Fatal("Expected callback context to be canceled")
+		}
+		return nil
+	}, ColumnAndValue("stop_id", "AA010"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled from StreamGTFSStopTimes, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("Unexpected number of callback calls after cancellation: %v", calls)
+	}
+}
+
+func TestResolveTimezone(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"agency","direction":"ASC","format":"json"},
+	                   "Gtfs":[{"id":"1","agency_name":"Test Agency",
+	                            "agency_url":"http://test.com",
+	                            "agency_timezone":"America/Vancouver",
+	                            "agency_lang":"","agency_phone":""}]}`
+
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/"/>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Riverview</RouteLabel>
+            <Direction>Westbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Riverview</TripDestination>
+                <TripStartTime>11:13</TripStartTime>
+                <AdjustedScheduleTime>16</AdjustedScheduleTime>
+                <AdjustmentAge>0.34</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Gtfs" {
+			fmt.Fprint(w, rawJSONString)
+			return
+		}
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	loc, err := c.ResolveTimezone(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.String() != "America/Vancouver" {
+		t.Fatalf("Unexpected location from ResolveTimezone: %v", loc)
+	}
+
+	nextTrips, err := c.GetNextTripsForStop(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextTrips.RouteDirections[0].RequestProcessingTime.Location().String() != "America/Vancouver" {
+		t.Fatal("Expected the resolved timezone to be used to parse RequestProcessingTime")
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Query":{"table":"agency"},"Gtfs":[{"id":"1","agency_timezone":"Nowhere/Special"}]}`)
+	}))
+	defer failing.Close()
+
+	c = NewConnection("", "")
+	c.cAPIURLPrefix = failing.URL + "/"
+
+	loc, err = c.ResolveTimezone(context.TODO())
+	if err == nil {
+		t.Fatal("Expected error from ResolveTimezone with an unknown timezone")
+	}
+	if loc == nil || loc.String() != "America/Toronto" {
+		t.Fatalf("Expected America/Toronto fallback from ResolveTimezone, got %v", loc)
+	}
+}
+
+func TestGetStopsInZone(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"stops","direction":"ASC",
+	                            "column":"zone_id","value":"1","format":"json"},
+	                   "Gtfs":[{"id":"1","stop_id":"AA010","stop_code":"8767",
+	                            "stop_name":"SUSSEX / CHUTE RIDEAU FALLS","zone_id":"1"},
+	                           {"id":"2","stop_id":"AA020","stop_code":"8768",
+	                            "stop_name":"SUSSEX / ALEXANDER","zone_id":"1"},
+	                           {"id":"3","stop_id":"AA030","stop_code":"8769",
+	                            "stop_name":"SUSSEX / KING EDWARD","zone_id":"2"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("column") != "zone_id" || r.URL.Query().Get("value") != "1" {
+			t.Errorf("Unexpected query for GetStopsInZone: %v", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	stops, err := c.GetStopsInZone(context.TODO(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stops.Gtfs) != 2 {
+		t.Fatalf("Unexpected number of stops returned by GetStopsInZone: %v", len(stops.Gtfs))
+	}
+	if stops.Gtfs[0].StopID != "AA010" || stops.Gtfs[1].StopID != "AA020" {
+		t.Fatal("Unexpected StopID in returned GTFSStops")
+	}
+}
+
+func TestFields(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"stops","direction":"ASC",
+	                            "column":"stop_code","value":"3020","format":"json"},
+	                   "Gtfs":[{"id":"12","stop_id":"AA010","stop_code":"3020",
+	                            "stop_name":"LAURIER 1A","stop_lat":"45.420734",
+	                            "stop_lon":"-75.680776","parent_station":""}]}`
+
+	var query url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	stops, err := c.GetGTFSStops(context.TODO(), ColumnAndValue("stop_code", "3020"), Fields("stop_id", "stop_name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range query {
+		switch key {
+		case "appID", "apiKey", "format", "table", "column", "value":
+		default:
+			t.Fatalf("Unexpected fields parameter sent to the API: %v", key)
+		}
+	}
+	if stops.Query.Table != "stops" {
+		t.Fatal("Unexpected Query in trimmed GTFSStops")
+	}
+	if len(stops.Gtfs) != 1 {
+		t.Fatal("Unexpected number of rows in trimmed GTFSStops")
+	}
+	if stops.Gtfs[0].StopID != "AA010" || stops.Gtfs[0].StopName != "LAURIER 1A" ||
+		stops.Gtfs[0].ID != "" || stops.Gtfs[0].StopCode != "" || stops.Gtfs[0].StopLat != "" {
+		t.Fatalf("Unexpected row in trimmed GTFSStops: %+v", stops.Gtfs[0])
+	}
+
+	_, err = c.GetGTFSStops(context.TODO(), ColumnAndValue("stop_code", "3020"), Fields())
+	if err == nil {
+		t.Fatal("Expected error from Fields without any fields")
+	}
+
+	v := url.Values{}
+	settings, err := applyOptions(v, []RequestOption{Fields("stop_id")})
+	if err != nil || len(settings.fields) != 1 || len(v) != 0 {
+		t.Fatal("Expected Fields to set the request's settings, not its parameters")
+	}
+}
+
+func TestStopCodeInt(t *testing.T) {
+	code, err := GTFSStopRow{StopCode: "3020"}.StopCodeInt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3020 {
+		t.Fatalf("Unexpected StopCodeInt: %v", code)
+	}
+
+	for _, stopCode := range []string{"RF900", "", "30 20"} {
+		_, err = GTFSStopRow{StopCode: stopCode}.StopCodeInt()
+		if err == nil {
+			t.Fatalf("Expected error from StopCodeInt for %q", stopCode)
+		}
+	}
+}
+
+func TestTripsBetweenStops(t *testing.T) {
+	rawFromJSONString := `{"Query":{"table":"stop_times","direction":"ASC",
+	                                "column":"stop_id","value":"AA010","format":"json"},
+	                       "Gtfs":[{"id":"1","trip_id":"1","arrival_time":"10:00:00",
+	                                "departure_time":"10:00:00","stop_id":"AA010","stop_sequence":"3"},
+	                               {"id":"2","trip_id":"2","arrival_time":"10:10:00",
+	                                "departure_time":"10:10:00","stop_id":"AA010","stop_sequence":"9"},
+	                               {"id":"3","trip_id":"3","arrival_time":"10:20:00",
+	                                "departure_time":"10:20:00","stop_id":"AA010","stop_sequence":"3"}]}`
+	rawToJSONString := `{"Query":{"table":"stop_times","direction":"ASC",
+	                              "column":"stop_id","value":"AA020","format":"json"},
+	                     "Gtfs":[{"id":"4","trip_id":"1","arrival_time":"10:05:00",
+	                              "departure_time":"10:05:00","stop_id":"AA020","stop_sequence":"5"},
+	                             {"id":"5","trip_id":"2","arrival_time":"10:05:00",
+	                              "departure_time":"10:05:00","stop_id":"AA020","stop_sequence":"5"},
+	                             {"id":"6","trip_id":"4","arrival_time":"10:25:00",
+	                              "departure_time":"10:25:00","stop_id":"AA020","stop_sequence":"5"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("value") == "AA010" {
+			fmt.Fprint(w, rawFromJSONString)
+			return
+		}
+		fmt.Fprint(w, rawToJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	tripIDs, err := c.TripsBetweenStops(context.TODO(), "AA010", "AA020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tripIDs) != 1 || tripIDs[0] != "1" {
+		t.Fatalf("Unexpected trip_ids from TripsBetweenStops: %v", tripIDs)
+	}
+}
+
+func TestServiceSpanForStop(t *testing.T) {
+	rawCalendarJSONString := `{"Query":{"table":"calendar","direction":"ASC","format":"json"},
+	                           "Gtfs":[{"id":"1","service_id":"Weekday",
+	                                    "monday":"1","tuesday":"1","wednesday":"1",
+	                                    "thursday":"1","friday":"1","saturday":"0",
+	                                    "sunday":"0","start_date":"20180801",
+	                                    "end_date":"20180930"},
+	                                   {"id":"2","service_id":"Saturday",
+	                                    "monday":"0","tuesday":"0","wednesday":"0",
+	                                    "thursday":"0","friday":"0","saturday":"1",
+	                                    "sunday":"0","start_date":"20180801",
+	                                    "end_date":"20180930"}]}`
+	rawCalendarDatesJSONString := `{"Query":{"table":"calendar_dates","direction":"ASC",
+	                                         "column":"date","value":"20180831","format":"json"},
+	                                "Gtfs":[]}`
+	rawTripsJSONString := `{"Query":{"table":"trips","direction":"ASC",
+	                                 "column":"service_id","value":"Weekday","format":"json"},
+	                        "Gtfs":[{"id":"1","route_id":"97-147","service_id":"Weekday","trip_id":"1"},
+	                                {"id":"2","route_id":"97-147","service_id":"Weekday","trip_id":"2"},
+	                                {"id":"3","route_id":"97-147","service_id":"Weekday","trip_id":"3"}]}`
+	rawStopTimesJSONString := `{"Query":{"table":"stop_times","direction":"ASC",
+	                                     "column":"stop_id","value":"AA010","format":"json"},
+	                           "Gtfs":[{"id":"1","trip_id":"2","arrival_time":"12:00:00",
+	                                    "departure_time":"12:00:00","stop_id":"AA010","stop_sequence":"3"},
+	                                   {"id":"2","trip_id":"1","arrival_time":"5:30:00",
+	                                    "departure_time":"5:30:00","stop_id":"AA010","stop_sequence":"3"},
+	                                   {"id":"3","trip_id":"3","arrival_time":"24:45:00",
+	                                    "departure_time":"24:45:00","stop_id":"AA010","stop_sequence":"3"},
+	                                   {"id":"4","trip_id":"4","arrival_time":"4:00:00",
+	                                    "departure_time":"4:00:00","stop_id":"AA010","stop_sequence":"3"}]}`
+
+	var tripsQuery url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("table") {
+		case "calendar":
+			fmt.Fprint(w, rawCalendarJSONString)
+		case "calendar_dates":
+			fmt.Fprint(w, rawCalendarDatesJSONString)
+		case "trips":
+			tripsQuery = r.URL.Query()
+			fmt.Fprint(w, rawTripsJSONString)
+		default:
+			fmt.Fprint(w, rawStopTimesJSONString)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	first, last, err := c.ServiceSpanForStop(context.TODO(), "AA010", time.Date(2018, time.August, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tripsQuery.Get("column") != "service_id" || tripsQuery.Get("value") != "Weekday" {
+		t.Fatal("Unexpected trips query from ServiceSpanForStop")
+	}
+	if first != 5*time.Hour+30*time.Minute {
+		t.Fatalf("Unexpected first departure from ServiceSpanForStop: %v", first)
+	}
+	if last != 24*time.Hour+45*time.Minute {
+		t.Fatalf("Unexpected last departure from ServiceSpanForStop: %v", last)
+	}
+
+	c = NewConnection("", "", WithGTFSValidation())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	first, last, err = c.ServiceSpanForStop(context.TODO(), "AA010", time.Date(2018, time.August, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Unexpected error from ServiceSpanForStop with WithGTFSValidation: %v", err)
+	}
+	if first != 5*time.Hour+30*time.Minute || last != 24*time.Hour+45*time.Minute {
+		t.Fatal("Unexpected departures from ServiceSpanForStop with WithGTFSValidation")
+	}
+}
+
+func TestServiceExceptionsBetween(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"calendar_dates","direction":"ASC","format":"json"},
+	                   "Gtfs":[{"id":"1","service_id":"Weekday",
+	                            "date":"20181225","exception_type":"2"},
+	                           {"id":"2","service_id":"Weekday",
+	                            "date":"20180903","exception_type":"2"},
+	                           {"id":"3","service_id":"Sunday",
+	                            "date":"20180903","exception_type":"1"},
+	                           {"id":"4","service_id":"Weekday",
+	                            "date":"20181008","exception_type":"2"},
+	                           {"id":"5","service_id":"Sunday",
+	                            "date":"20180702","exception_type":"1"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	start := time.Date(2018, time.September, 3, 15, 0, 0, 0, time.UTC)
+	end := time.Date(2018, time.October, 8, 9, 0, 0, 0, time.UTC)
+	exceptions, err := c.ServiceExceptionsBetween(context.TODO(), start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exceptions) != 3 {
+		t.Fatalf("Unexpected number of rows from ServiceExceptionsBetween: %v", len(exceptions))
+	}
+	if exceptions[0].ID != "2" || exceptions[1].ID != "3" || exceptions[2].ID != "4" {
+		t.Fatalf("Unexpected rows from ServiceExceptionsBetween: %v", exceptions)
+	}
+}
+
+func TestScheduledMinutesAway(t *testing.T) {
+	rawStopJSONString := `{"Query":{"table":"stop_times","direction":"ASC",
+	                                "column":"stop_id","value":"AA010","format":"json"},
+	                       "Gtfs":[{"id":"1","trip_id":"C","arrival_time":"13:30:00",
+	                                "departure_time":"13:30:00","stop_id":"AA010","stop_sequence":"20"},
+	                               {"id":"2","trip_id":"B","arrival_time":"11:50:00",
+	                                "departure_time":"11:50:00","stop_id":"AA010","stop_sequence":"20"},
+	                               {"id":"3","trip_id":"A","arrival_time":"11:45:00",
+	                                "departure_time":"11:45:00","stop_id":"AA010","stop_sequence":"20"}]}`
+	rawTripJSONString := `{"Query":{"table":"stop_times","direction":"ASC",
+	                                "column":"trip_id","value":"%v","format":"json"},
+	                       "Gtfs":[{"id":"4","trip_id":"%[1]v","arrival_time":"11:55:00",
+	                                "departure_time":"11:55:00","stop_id":"AA020","stop_sequence":"21"},
+	                               {"id":"5","trip_id":"%[1]v","arrival_time":"%[2]v",
+	                                "departure_time":"%[2]v","stop_id":"AA000","stop_sequence":"1"}]}`
+	tripStarts := map[string]string{"A": "11:00:00", "B": "11:13:00", "C": "13:00:00"}
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("column") == "trip_id" {
+			tripID := r.URL.Query().Get("value")
+			fmt.Fprintf(w, rawTripJSONString, tripID, tripStarts[tripID])
+			return
+		}
+		fmt.Fprint(w, rawStopJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	toronto, err := time.LoadLocation("America/Toronto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The trip starts at 11:13, and is expected in 16 minutes, at 11:56.
+	rd := RouteDirection{RequestProcessingTime: time.Date(2018, 8, 31, 11, 40, 42, 0, toronto)}
+	trip := Trip{TripStartTime: "11:13", AdjustedScheduleTime: 16}
+
+	scheduled, err := c.ScheduledMinutesAway(context.TODO(), "AA010", rd, trip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheduled != 9 {
+		t.Fatalf("Unexpected ScheduledMinutesAway: %v", scheduled)
+	}
+	if trip.DelayVsSchedule(scheduled) != 7 {
+		t.Fatalf("Unexpected DelayVsSchedule: %v", trip.DelayVsSchedule(scheduled))
+	}
+
+	trip.TripStartTime = "10:30"
+	_, err = c.ScheduledMinutesAway(context.TODO(), "AA010", rd, trip)
+	if err == nil {
+		t.Fatal("Expected error from ScheduledMinutesAway without a matching trip")
+	}
+}
+
+func TestCountGTFSRows(t *testing.T) {
+	responses := map[string]string{
+		"AA010": `{"Query":{"table":"stop_times","direction":"ASC","column":"stop_id",
+		                    "value":"AA010","limit":"1","format":"json","total":"1234"},
+		           "Gtfs":[{"id":"1","trip_id":"1","stop_id":"AA010","stop_sequence":"3"}]}`,
+		"AA020": `{"Query":{"table":"stop_times","direction":"ASC","column":"stop_id",
+		                    "value":"AA020","limit":"1","format":"json"},
+		           "total":56,
+		           "Gtfs":[{"id":"2","trip_id":"2","stop_id":"AA020","stop_sequence":"3"}]}`,
+		"AA030": `{"Query":{"table":"stop_times","direction":"ASC","column":"stop_id",
+		                    "value":"AA030","limit":"1","format":"json"},
+		           "Gtfs":[{"id":"3","trip_id":"3","stop_id":"AA030","stop_sequence":"3"}]}`,
+	}
+
+	var query url.Values
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		fmt.Fprint(w, responses[query.Get("value")])
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	count, err := c.CountGTFSRows(context.TODO(), "stop_times", ColumnAndValue("stop_id", "AA010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1234 {
+		t.Fatalf("Unexpected count from CountGTFSRows: %v", count)
+	}
+	if query.Get("table") != "stop_times" || query.Get("limit") != "1" {
+		t.Fatal("Unexpected query from CountGTFSRows")
+	}
+
+	count, err = c.CountGTFSRows(context.TODO(), "stop_times", ColumnAndValue("stop_id", "AA020"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 56 {
+		t.Fatalf("Unexpected count from CountGTFSRows with a top level total: %v", count)
+	}
+
+	_, err = c.CountGTFSRows(context.TODO(), "stop_times", ColumnAndValue("stop_id", "AA030"))
+	if !errors.Is(err, ErrCountUnavailable) {
+		t.Fatalf("Expected ErrCountUnavailable without a total, got %v", err)
+	}
+}
+
+func TestParseTripID(t *testing.T) {
+	parts, err := ParseTripID("27212870-CADA13-CADA13-Sunday-71")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts.Sequence != 27212870 || parts.Day != "Sunday" || parts.Suffix != "71" {
+		t.Fatal("Unexpected parts in returned TripIDParts")
+	}
+	if len(parts.BookingCodes) != 2 || parts.BookingCodes[0] != "CADA13" || parts.BookingCodes[1] != "CADA13" {
+		t.Fatal("Unexpected BookingCodes in returned TripIDParts")
+	}
+	weekday, ok := parts.Weekday()
+	if !ok || weekday != time.Sunday {
+		t.Fatal("Unexpected Weekday in returned TripIDParts")
+	}
+
+	parts, err = ParseTripID("27210104-CADA13-CADA13-Saturday-71")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weekday, ok = parts.Weekday()
+	if !ok || weekday != time.Saturday {
+		t.Fatal("Unexpected Weekday in returned TripIDParts")
+	}
+
+	parts, err = ParseTripID("27210104-CADA13-Weekday-71")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts.BookingCodes) != 1 || parts.Day != "Weekday" {
+		t.Fatal("Unexpected parts in returned TripIDParts")
+	}
+	_, ok = parts.Weekday()
+	if ok {
+		t.Fatal("Expected no Weekday for a Weekday trip")
+	}
+
+	for _, id := range []string{"", "27210104", "abc-CADA13-Sunday-71", "27210104-CADA13--71"} {
+		_, err = ParseTripID(id)
+		if !errors.Is(err, ErrInvalidTripID) {
+			t.Fatalf("Expected ErrInvalidTripID for %q, got %v", id, err)
+		}
+	}
+}
+
+func TestStopsForTrip(t *testing.T) {
+	stopTimesJSON := `{"Query":{"table":"stop_times","direction":"ASC","column":"trip_id",
+	                            "value":"27212870-CADA13-CADA13-Sunday-71","format":"json"},
+	                   "Gtfs":[{"id":"3","trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AA010","stop_sequence":"10"},
+	                           {"id":"1","trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AA010","stop_sequence":"1"},
+	                           {"id":"2","trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AF920","stop_sequence":"2"}]}`
+	stopsJSON := map[string]string{
+		"AA010": `{"Query":{"table":"stops","direction":"ASC","column":"stop_id","value":"AA010","format":"json"},
+		           "Gtfs":[{"id":"1","stop_id":"AA010","stop_code":"8767","stop_name":"SUSSEX \/ RIDEAU FALLS",
+		                    "stop_lat":"45.4399","stop_lon":"-75.6951"}]}`,
+		"AF920": `{"Query":{"table":"stops","direction":"ASC","column":"stop_id","value":"AF920","format":"json"},
+		           "Gtfs":[{"id":"2","stop_id":"AF920","stop_code":"3020","stop_name":"LAURIER",
+		                    "stop_lat":"45.4163","stop_lon":"-75.6848"}]}`,
+	}
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("table") == "stop_times" {
+			fmt.Fprint(w, stopTimesJSON)
+			return
+		}
+		mu.Lock()
+		requests[q.Get("value")]++
+		mu.Unlock()
+		fmt.Fprint(w, stopsJSON[q.Get("value")])
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	stops, err := c.StopsForTrip(context.TODO(), "27212870-CADA13-CADA13-Sunday-71")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stops) != 3 {
+		t.Fatalf("Unexpected number of stops returned from StopsForTrip: %v", len(stops))
+	}
+	if stops[0].StopID != "AA010" || stops[1].StopID != "AF920" || stops[2].StopID != "AA010" {
+		t.Fatal("Unexpected order of stops returned from StopsForTrip")
+	}
+	if stops[1].StopLat != "45.4163" || stops[1].StopLon != "-75.6848" {
+		t.Fatal("Unexpected coordinates in stops returned from StopsForTrip")
+	}
+	if requests["AA010"] != 1 || requests["AF920"] != 1 {
+		t.Fatal("Expected each stop to be fetched once")
+	}
+
+	// Rows without an id, such as with Fields, are still sorted by stop_sequence.
+	stopTimesJSON = `{"Query":{"table":"stop_times","direction":"ASC","column":"trip_id",
+	                            "value":"27212870-CADA13-CADA13-Sunday-71","format":"json"},
+	                   "Gtfs":[{"trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AF920","stop_sequence":"2"},
+	                           {"trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AA010","stop_sequence":"10"},
+	                           {"trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AA010","stop_sequence":"1"}]}`
+	stops, err = c.StopsForTrip(context.TODO(), "27212870-CADA13-CADA13-Sunday-71")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stops) != 3 || stops[0].StopID != "AA010" || stops[1].StopID != "AF920" || stops[2].StopID != "AA010" {
+		t.Fatal("Unexpected order of stops without ids returned from StopsForTrip")
+	}
+
+	stopTimesJSON = `{"Query":{"table":"stop_times","direction":"ASC","column":"trip_id",
+	                            "value":"27212870-CADA13-CADA13-Sunday-71","format":"json"},
+	                   "Gtfs":[{"id":"1","trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AA010","stop_sequence":"first"}]}`
+	_, err = c.StopsForTrip(context.TODO(), "27212870-CADA13-CADA13-Sunday-71")
+	if err == nil || !strings.Contains(err.Error(), "invalid stop_sequence") {
+		t.Fatalf("Expected invalid stop_sequence error from StopsForTrip, got %v", err)
+	}
+}
+
+func TestFanOutPartialOnTimeout(t *testing.T) {
+	stopTimesJSON := `{"Query":{"table":"stop_times","direction":"ASC","column":"trip_id",
+	                            "value":"27212870-CADA13-CADA13-Sunday-71","format":"json"},
+	                   "Gtfs":[{"id":"1","trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AA010","stop_sequence":"1"},
+	                           {"id":"2","trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AF920","stop_sequence":"2"}]}`
+	stopJSON := `{"Query":{"table":"stops","direction":"ASC","column":"stop_id","value":"AA010","format":"json"},
+	              "Gtfs":[{"id":"1","stop_id":"AA010","stop_code":"8767","stop_name":"SUSSEX \/ RIDEAU FALLS",
+	                       "stop_lat":"45.4399","stop_lon":"-75.6951"}]}`
+
+	done := make(chan struct{})
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("table") == "stop_times" && q.Get("column") == "trip_id":
+			fmt.Fprint(w, stopTimesJSON)
+		case q.Get("table") == "stops" && q.Get("value") == "AA010":
+			fmt.Fprint(w, stopJSON)
+		default:
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+	defer close(done)
+
+	c := NewConnection("", "", WithPartialOnTimeout())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	stops, err := c.StopsForTrip(ctx, "27212870-CADA13-CADA13-Sunday-71")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded from StopsForTrip, got %v", err)
+	}
+	if len(stops) != 1 || stops[0].StopID != "AA010" {
+		t.Fatal("Expected the stop which responded from StopsForTrip")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	data, err := c.PrefetchForStop(ctx, "AA010")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded from PrefetchForStop, got %v", err)
+	}
+	if data == nil || data.Stop.StopID != "AA010" || data.StopTimes != nil || data.Routes != nil {
+		t.Fatal("Expected the stop's row which responded from PrefetchForStop")
+	}
+
+	c = NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	stops, err = c.StopsForTrip(ctx, "27212870-CADA13-CADA13-Sunday-71")
+	if !errors.Is(err, context.DeadlineExceeded) || stops != nil {
+		t.Fatal("Expected no stops from StopsForTrip without WithPartialOnTimeout")
+	}
+}
+
+func TestShapesToGeoJSON(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"shapes","direction":"ASC","column":"shape_id","value":"1-146","format":"json"},
+	                   "Gtfs":[{"id":"2","shape_id":"1-146","shape_pt_lat":"45.4163","shape_pt_lon":"-75.6848","shape_pt_sequence":"2"},
+	                           {"id":"1","shape_id":"1-146","shape_pt_lat":"45.4399","shape_pt_lon":"-75.6951","shape_pt_sequence":"1"},
+	                           {"id":"3","shape_id":"1-147","shape_pt_lat":"45.4000","shape_pt_lon":"-75.7000","shape_pt_sequence":"1"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	_, err := c.GetGTFSShapes(context.TODO())
+	if err == nil {
+		t.Fatal("Expected error without a shape_id or id")
+	}
+	shapes, err := c.GetGTFSShapes(context.TODO(), ColumnAndValue("shape_id", "1-146"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := shapes.ToGeoJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo := struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]string `json:"properties"`
+		} `json:"features"`
+	}{}
+	err = json.Unmarshal(raw, &geo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geo.Type != "FeatureCollection" || len(geo.Features) != 2 {
+		t.Fatal("Unexpected FeatureCollection in returned GeoJSON")
+	}
+	first := geo.Features[0]
+	if first.Type != "Feature" || first.Geometry.Type != "LineString" || first.Properties["shape_id"] != "1-146" {
+		t.Fatal("Unexpected Feature in returned GeoJSON")
+	}
+	var line [][]float64
+	err = json.Unmarshal(first.Geometry.Coordinates, &line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(line) != 2 || line[0][0] != -75.6951 || line[0][1] != 45.4399 {
+		t.Fatal("Unexpected coordinates in returned GeoJSON")
+	}
+	second := geo.Features[1]
+	if second.Geometry.Type != "Point" || second.Properties["shape_id"] != "1-147" {
+		t.Fatal("Expected a Point Feature for a shape with a single point in returned GeoJSON")
+	}
+	var point []float64
+	err = json.Unmarshal(second.Geometry.Coordinates, &point)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(point) != 2 || point[0] != -75.7 || point[1] != 45.4 {
+		t.Fatal("Unexpected coordinates for a single point shape in returned GeoJSON")
+	}
+
+	shapes.Gtfs[0].ShapePtLat = "north"
+	_, err = shapes.ToGeoJSON()
+	if err == nil {
+		t.Fatal("Expected error for an invalid shape_pt_lat")
+	}
+}
+
+func TestStopsToGeoJSON(t *testing.T) {
+	stops := &GTFSStops{Gtfs: []GTFSStopRow{
+		{StopID: "AA010", StopCode: "8767", StopName: "SUSSEX / RIDEAU FALLS", StopLat: "45.4399", StopLon: "-75.6951"},
+		{StopID: "AA020", StopCode: "8768", StopName: "SUSSEX / ALEXANDER"},
+		{StopID: "AF920", StopCode: "3020", StopName: "LAURIER", StopLat: "45.4163", StopLon: "-75.6848"},
+	}}
+
+	raw, err := stops.ToGeoJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo := struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			Geometry struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]string `json:"properties"`
+		} `json:"features"`
+		Warnings []string `json:"warnings"`
+	}{}
+	err = json.Unmarshal(raw, &geo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geo.Type != "FeatureCollection" || len(geo.Features) != 2 {
+		t.Fatal("Unexpected FeatureCollection in returned GeoJSON")
+	}
+	last := geo.Features[1]
+	if last.Geometry.Type != "Point" || last.Properties["stop_name"] != "LAURIER" || last.Properties["stop_code"] != "3020" {
+		t.Fatal("Unexpected Feature in returned GeoJSON")
+	}
+	if len(last.Geometry.Coordinates) != 2 || last.Geometry.Coordinates[0] != -75.6848 || last.Geometry.Coordinates[1] != 45.4163 {
+		t.Fatal("Unexpected coordinates in returned GeoJSON")
+	}
+	if len(geo.Warnings) != 1 || !strings.Contains(geo.Warnings[0], "AA020") {
+		t.Fatal("Expected a warning for the stop without coordinates")
+	}
+}
+
+func TestPrefetchForStop(t *testing.T) {
+	rawJSONStrings := map[string]string{
+		"stops": `{"Query":{"table":"stops","direction":"ASC","column":"stop_id","value":"AF920","format":"json"},
+		           "Gtfs":[{"id":"2","stop_id":"AF920","stop_code":"3020","stop_name":"LAURIER",
+		                    "stop_lat":"45.4163","stop_lon":"-75.6848"}]}`,
+		"stop_times": `{"Query":{"table":"stop_times","direction":"ASC","column":"stop_id","value":"AF920","format":"json"},
+		                "Gtfs":[{"id":"1","trip_id":"27212870-CADA13-CADA13-Sunday-71","stop_id":"AF920",
+		                         "departure_time":"13:14:00","stop_sequence":"2"}]}`,
+	}
+
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+          </Route>
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Westbound</Direction>
+            <RouteHeading>Bayshore</RouteHeading>
+          </Route>
+          <Route>
+            <RouteNo>98</RouteNo>
+            <DirectionID>1</DirectionID>
+            <Direction>Westbound</Direction>
+            <RouteHeading>Tunney's Pasture</RouteHeading>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	var mu sync.Mutex
+	var paths []string
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/Gtfs" {
+			fmt.Fprint(w, rawJSONStrings[r.URL.Query().Get("table")])
+			return
+		}
+		if r.FormValue("stopNo") != "3020" {
+			t.Errorf("Unexpected stopNo for the route summary: %v", r.FormValue("stopNo"))
+		}
+		fmt.Fprint(w, rawXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnectionWithRateLimit("", "", 100, 2)
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	data, err := c.PrefetchForStop(context.TODO(), "AF920")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Stop.StopName != "LAURIER" {
+		t.Fatal("Unexpected Stop in StopStaticData")
+	}
+	if len(data.StopTimes) != 1 || data.StopTimes[0].DepartureTime != "13:14:00" {
+		t.Fatal("Unexpected StopTimes in StopStaticData")
+	}
+	if len(data.Routes) != 3 || data.Routes[0].RouteNo != "97" {
+		t.Fatal("Unexpected Routes in StopStaticData")
+	}
+	if len(paths) != 3 || paths[2] != "/GetRouteSummaryForStop" {
+		t.Fatalf("Unexpected requests from PrefetchForStop: %v", paths)
+	}
+}
+
+func TestGetRoutesByType(t *testing.T) {
+	rawJSONString := `{"Query":{"table":"routes","direction":"ASC",
+	                            "column":"route_type","value":"3","format":"json"},
+	                   "Gtfs":[{"id":"1","route_id":"1-350","route_short_name":"1",
+	                            "route_long_name":"","route_type":"0"},
+	                           {"id":"2","route_id":"6-350","route_short_name":"6",
+	                            "route_long_name":"","route_type":"3"},
+	                           {"id":"3","route_id":"97-350","route_short_name":"97",
+	                            "route_long_name":"","route_type":"3"}]}`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("column") != "route_type" || r.URL.Query().Get("value") != "3" {
+			t.Errorf("Unexpected query for GetRoutesByType: %v", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, rawJSONString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	routes, err := c.GetRoutesByType(context.TODO(), RouteTypeBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes.Gtfs) != 2 {
+		t.Fatalf("Unexpected number of routes returned by GetRoutesByType: %v", len(routes.Gtfs))
+	}
+	if routes.Gtfs[0].RouteShortName != "6" || routes.Gtfs[1].RouteShortName != "97" {
+		t.Fatal("Unexpected RouteShortName in returned GTFSRoutes")
+	}
+}
+
+func TestNextAfter(t *testing.T) {
+	times := &GTFSStopTimes{Gtfs: []GTFSStopTimeRow{
+		{TripID: "1", DepartureTime: "17:00:00"},
+		{TripID: "2", DepartureTime: "17:30:00"},
+		{TripID: "3", DepartureTime: "17:45:00"},
+		{TripID: "4", DepartureTime: "23:50:00"},
+		{TripID: "5", DepartureTime: "24:20:00"},
+	}}
+
+	tests := []struct {
+		after  time.Duration
+		tripID string
+	}{
+		{17*time.Hour + 29*time.Minute, "2"},
+		{17*time.Hour + 30*time.Minute, "3"},
+		{16 * time.Hour, "1"},
+		{23*time.Hour + 55*time.Minute, "5"},
+		{10 * time.Minute, "5"},
+	}
+	for _, test := range tests {
+		next, ok := times.NextAfter(test.after)
+		if !ok || next.TripID != test.tripID {
+			t.Fatalf("Unexpected departure after %v: %v", test.after, next.TripID)
+		}
+	}
+	_, ok := times.NextAfter(24*time.Hour + 20*time.Minute)
+	if ok {
+		t.Fatal("Expected no departure after the last one")
+	}
+}
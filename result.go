@@ -0,0 +1,67 @@
+package gooctranspoapi
+
+import (
+	"context"
+	"time"
+)
+
+// Result wraps the data returned by a request with metadata about the request,
+// as returned by the Result variants of the Connection's methods, such as
+// GetNextTripsForStopAllRoutesResult.
+type Result[T any] struct {
+	Data T
+	// FetchedAt is when the response was received and decoded.
+	FetchedAt time.Time
+	// Latency is how long the API took to respond, not including waiting for the rate limiter.
+	// It's zero when the response was shared by WithRequestCoalescing.
+	Latency time.Duration
+	// APIError is the text of the Error field of the response, which is empty unless
+	// the API returned an error without a code.
+	APIError string
+}
+
+// newResult returns a Result for data, taking the Latency from timing, which is
+// cleared unless the connection has WithCaptureTiming, as if the timing wasn't captured.
+func newResult[T any](c Connection, data T, apiError string, timing **RequestInfo) Result[T] {
+	r := Result[T]{Data: data, FetchedAt: time.Now(), APIError: apiError}
+	if *timing != nil {
+		r.Latency = (*timing).Latency
+	}
+	if !c.captureTiming {
+		*timing = nil
+	}
+	return r
+}
+
+// GetRouteSummaryForStopResult is GetRouteSummaryForStop, with the result wrapped in a Result.
+func (c Connection) GetRouteSummaryForStopResult(ctx context.Context, stopNo string, options ...RequestOption) (Result[*RouteSummaryForStop], error) {
+	timed := c
+	timed.captureTiming = true
+	data, err := timed.GetRouteSummaryForStop(ctx, stopNo, options...)
+	if err != nil {
+		return Result[*RouteSummaryForStop]{}, err
+	}
+	return newResult(c, data, data.Error, &data.Timing), nil
+}
+
+// GetNextTripsForStopResult is GetNextTripsForStop, with the result wrapped in a Result.
+func (c Connection) GetNextTripsForStopResult(ctx context.Context, routeNo, stopNo string, options ...RequestOption) (Result[*NextTripsForStop], error) {
+	timed := c
+	timed.captureTiming = true
+	data, err := timed.GetNextTripsForStop(ctx, routeNo, stopNo, options...)
+	if err != nil {
+		return Result[*NextTripsForStop]{}, err
+	}
+	return newResult(c, data, data.Error, &data.Timing), nil
+}
+
+// GetNextTripsForStopAllRoutesResult is GetNextTripsForStopAllRoutes, with the result wrapped in a Result.
+func (c Connection) GetNextTripsForStopAllRoutesResult(ctx context.Context, stopNo string, options ...RequestOption) (Result[*NextTripsForStopAllRoutes], error) {
+	timed := c
+	timed.captureTiming = true
+	data, err := timed.GetNextTripsForStopAllRoutes(ctx, stopNo, options...)
+	if err != nil {
+		return Result[*NextTripsForStopAllRoutes]{}, err
+	}
+	return newResult(c, data, data.Error, &data.Timing), nil
+}
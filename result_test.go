@@ -0,0 +1,135 @@
+package gooctranspoapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResult(t *testing.T) {
+	rawXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetNextTripsForStopResponse xmlns="http://octranspo.com">
+      <GetNextTripsForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopLabel xmlns="http://tempuri.org/">LAURIER STATION</StopLabel>
+        <Error xmlns="http://tempuri.org/">TestErrorStringHere</Error>
+        <Route xmlns="http://tempuri.org/">
+          <RouteDirection>
+            <RouteNo>94</RouteNo>
+            <RouteLabel>Riverview</RouteLabel>
+            <Direction>Westbound</Direction>
+            <Error/>
+            <RequestProcessingTime>20180831114042</RequestProcessingTime>
+            <Trips>
+              <Trip>
+                <TripDestination>Riverview</TripDestination>
+                <TripStartTime>11:13</TripStartTime>
+                <AdjustedScheduleTime>16</AdjustedScheduleTime>
+                <AdjustmentAge>0.34</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </RouteDirection>
+        </Route>
+      </GetNextTripsForStopResult>
+    </GetNextTripsForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawAllRoutesXMLString := `<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <soap:Body>
+    <GetRouteSummaryForStopResponse xmlns="http://octranspo.com">
+      <GetRouteSummaryForStopResult>
+        <StopNo xmlns="http://tempuri.org/">3020</StopNo>
+        <StopDescription xmlns="http://tempuri.org/">LAURIER STATION</StopDescription>
+        <Error xmlns="http://tempuri.org/"/>
+        <Routes xmlns="http://tempuri.org/">
+          <Route>
+            <RouteNo>97</RouteNo>
+            <DirectionID>0</DirectionID>
+            <Direction>Eastbound</Direction>
+            <RouteHeading>Airport / Aéroport</RouteHeading>
+            <Trips>
+              <Trip>
+                <TripDestination>Airport / Aéroport</TripDestination>
+                <TripStartTime>13:14</TripStartTime>
+                <AdjustedScheduleTime>8</AdjustedScheduleTime>
+                <AdjustmentAge>0.42</AdjustmentAge>
+                <LastTripOfSchedule/>
+                <BusType>6EB - 60</BusType>
+                <Latitude/>
+                <Longitude/>
+                <GPSSpeed/>
+              </Trip>
+            </Trips>
+          </Route>
+        </Routes>
+      </GetRouteSummaryForStopResult>
+    </GetRouteSummaryForStopResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+	rawHandler := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		if r.URL.Path == "/GetNextTripsForStop" {
+			fmt.Fprint(w, rawXMLString)
+			return
+		}
+		fmt.Fprint(w, rawAllRoutesXMLString)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(rawHandler))
+	defer ts.Close()
+
+	c := NewConnection("", "")
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	before := time.Now()
+	result, err := c.GetNextTripsForStopAllRoutesResult(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Data.StopNo != "3020" || len(result.Data.Routes) != 1 {
+		t.Fatal("Unexpected Data in returned Result")
+	}
+	if result.FetchedAt.Before(before) || result.FetchedAt.After(time.Now()) {
+		t.Fatalf("Unexpected FetchedAt in returned Result: %v", result.FetchedAt)
+	}
+	if result.Latency < 20*time.Millisecond {
+		t.Fatalf("Unexpected Latency in returned Result: %v", result.Latency)
+	}
+	if result.APIError != "" {
+		t.Fatal("Unexpected APIError in returned Result")
+	}
+	if result.Data.Timing != nil {
+		t.Fatal("Expected no Timing without WithCaptureTiming")
+	}
+
+	tripsResult, err := c.GetNextTripsForStopResult(context.TODO(), "94", "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tripsResult.APIError != "TestErrorStringHere" {
+		t.Fatalf("Unexpected APIError in returned Result: %v", tripsResult.APIError)
+	}
+
+	c = NewConnection("", "", WithCaptureTiming())
+	c.cAPIURLPrefix = ts.URL + "/"
+
+	summaryResult, err := c.GetRouteSummaryForStopResult(context.TODO(), "3020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summaryResult.Data.Timing == nil || summaryResult.Data.Timing.Latency != summaryResult.Latency {
+		t.Fatal("Expected Timing to match the Latency with WithCaptureTiming")
+	}
+}